@@ -59,3 +59,11 @@ type APIString string
 func (s APIString) HumanReadable(string) ([]byte, error) {
 	return []byte(s), nil
 }
+
+// APIBoolean represents a boolean in the API
+type APIBoolean bool
+
+// HumanReadable returns the APIBoolean as a string
+func (b APIBoolean) HumanReadable(string) ([]byte, error) {
+	return []byte(strconv.FormatBool(bool(b))), nil
+}
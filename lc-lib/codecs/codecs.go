@@ -18,13 +18,16 @@ package codecs
 
 import (
 	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 // Codec is the generic interface that all codecs implement
 type Codec interface {
 	Teardown() int64
 	Reset()
-	Event(int64, int64, string)
+	Flush()
+	Event(int64, int64, string, core.Event)
 	Meter()
 	APIEncodable() admin.APIEncodable
 }
@@ -32,7 +35,12 @@ type Codec interface {
 // CallbackFunc is a callback function that a codec will call for each of its
 // "output" events. It could be called at any time by any routine (not
 // necessarily the routine providing the "input" events.)
-type CallbackFunc func(int64, int64, string)
+//
+// Along with the offsets and text, each event carries the structured fields
+// that will be shipped with it. The fields passed to a codec belong to that
+// codec, which may modify them before passing them on. A codec must pass each
+// fields map on at most once, copying it if it outputs more than one event
+type CallbackFunc func(int64, int64, string, core.Event)
 
 // codecFactory is the interface that all codec factories implement. The codec
 // factory should store the codec's configuration and, when NewCodec is called,
@@ -41,7 +49,79 @@ type codecFactory interface {
 	NewCodec(CallbackFunc, int64) Codec
 }
 
+// partialCodec is implemented by codecs that need to know when a file is read
+// from part way through an event, rather than from the beginning of the file or
+// from an offset saved after an event was shipped
+type partialCodec interface {
+	StartPartial()
+}
+
+// StartPartial tells the codecs in a chain that the first line they receive
+// may be part way through an event, such as when a file that existed before
+// startup is read from its end. It must be called before the first line is
+// passed to the chain
+func StartPartial(chain []Codec) {
+	for _, codec := range chain {
+		if partial, ok := codec.(partialCodec); ok {
+			partial.StartPartial()
+		}
+	}
+}
+
+// headerCodec is implemented by codecs that use the first line of a file, and
+// so need it passed to them when a file is read from part way through
+type headerCodec interface {
+	NeedsHeader() bool
+	SetHeader(string)
+}
+
+// NeedsHeader returns true if any codec in a chain needs the first line of the
+// file when it is read from part way through
+func NeedsHeader(chain []Codec) bool {
+	for _, codec := range chain {
+		if header, ok := codec.(headerCodec); ok && header.NeedsHeader() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeader passes the first line of a file that is read from part way through
+// to the codecs in a chain that need it. It must be called before the first
+// line is passed to the chain
+func SetHeader(chain []Codec, text string) {
+	for _, codec := range chain {
+		if header, ok := codec.(headerCodec); ok {
+			header.SetHeader(text)
+		}
+	}
+}
+
 // NewCodec returns a Codec interface initialised from the given Factory
 func NewCodec(factory interface{}, callbackFunc CallbackFunc, offset int64) Codec {
 	return factory.(codecFactory).NewCodec(callbackFunc, offset)
 }
+
+// NewCodecChain returns Codec instances for a list of codec configurations,
+// in the order they are used. Each passes its events to the next, and the last
+// passes them to the given callback
+func NewCodecChain(stubs []config.CodecStub, callbackFunc CallbackFunc, offset int64) []Codec {
+	chain := make([]Codec, len(stubs))
+	for i := len(stubs) - 1; i >= 0; i-- {
+		chain[i] = NewCodec(stubs[i].Factory, callbackFunc, offset)
+		callbackFunc = chain[i].Event
+	}
+	return chain
+}
+
+// setField stores a value in the event fields for a codec that decodes fields
+// from the line. An existing value is left alone unless overwrite is true
+func setField(fields core.Event, key string, value interface{}, overwrite bool) {
+	if !overwrite {
+		if _, ok := fields[key]; ok {
+			return
+		}
+	}
+
+	fields[key] = value
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// containerLogMaxBytes validates the "max message bytes" option of the codecs
+// that unwrap container runtime log formats, returning the default if it was
+// not set
+func containerLogMaxBytes(config *config.Config, maxBytes int64) (int64, error) {
+	if maxBytes == 0 {
+		return config.General.SpoolMaxBytes, nil
+	}
+
+	if maxBytes < 0 || maxBytes > config.General.SpoolMaxBytes {
+		return 0, errors.New("max message bytes must be positive and cannot be greater than /general/spool max bytes")
+	}
+
+	return maxBytes, nil
+}
+
+// containerLog is embedded in the codecs that unwrap container runtime log
+// formats. It reassembles messages that the runtime split into partial lines
+// and ships them, along with the stream and time they were logged with
+type containerLog struct {
+	maxBytes     int64
+	lastOffset   int64
+	callbackFunc CallbackFunc
+
+	endOffset    int64
+	messages     map[string]*containerLogMessage
+	partialLines uint64
+	failedLines  uint64
+
+	meterPartial uint64
+	meterFailed  uint64
+	meterBytes   int64
+}
+
+// containerLogMessage holds a message being reassembled from the partial
+// lines of one stream
+type containerLogMessage struct {
+	startOffset int64
+	endOffset   int64
+	stream      string
+	time        string
+	fields      core.Event
+	buffer      []string
+	bufferLen   int64
+}
+
+// newContainerLog initialises the common codec state
+func newContainerLog(maxBytes int64, callbackFunc CallbackFunc, offset int64) containerLog {
+	return containerLog{
+		maxBytes:     maxBytes,
+		lastOffset:   offset,
+		endOffset:    offset,
+		callbackFunc: callbackFunc,
+		messages:     make(map[string]*containerLogMessage),
+	}
+}
+
+// Teardown ends the codec and returns the offset to resume from, which is the
+// start of the earliest message still being reassembled, or the last offset
+// shipped to the callback if there is none
+func (c *containerLog) Teardown() int64 {
+	return c.pendingOffset(c.lastOffset)
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *containerLog) Reset() {
+	c.lastOffset = 0
+	c.endOffset = 0
+	c.messages = make(map[string]*containerLogMessage)
+}
+
+// Flush sends any partially reassembled messages to the callback immediately
+func (c *containerLog) Flush() {
+	for len(c.messages) != 0 {
+		var first *containerLogMessage
+		for _, message := range c.messages {
+			if first == nil || message.startOffset < first.startOffset {
+				first = message
+			}
+		}
+		c.flush(first)
+	}
+}
+
+// line handles a decoded line. A partial line is buffered until the line from
+// the same stream that completes it arrives, or until the message grows too
+// large, in which case it is shipped incomplete. Each stream is reassembled
+// separately, so the partial lines of a message may be interleaved with lines
+// from another stream
+func (c *containerLog) line(startOffset int64, endOffset int64, stream string, time string, text string, partial bool, fields core.Event) {
+	c.endOffset = endOffset
+
+	message, ok := c.messages[stream]
+	if !ok {
+		if fields == nil {
+			fields = core.Event{}
+		}
+		message = &containerLogMessage{
+			startOffset: startOffset,
+			stream:      stream,
+			time:        time,
+			fields:      fields,
+		}
+		c.messages[stream] = message
+	}
+
+	message.endOffset = endOffset
+	message.buffer = append(message.buffer, text)
+	message.bufferLen += int64(len(text))
+
+	if partial {
+		c.partialLines++
+		if message.bufferLen < c.maxBytes {
+			return
+		}
+	}
+
+	c.flush(message)
+}
+
+// failed ships a line that could not be decoded unchanged with the given tag,
+// after any messages that were being reassembled
+func (c *containerLog) failed(startOffset int64, endOffset int64, text string, fields core.Event, tag string) {
+	c.Flush()
+
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	c.endOffset = endOffset
+	c.failedLines++
+	fields.AddTag(tag)
+
+	c.lastOffset = endOffset
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// flush ships a reassembled message. While a message from another stream is
+// still being reassembled, the end offset passed on is no later than the
+// start of that message, so that it is read again if harvesting resumes
+func (c *containerLog) flush(message *containerLogMessage) {
+	delete(c.messages, message.stream)
+
+	text := strings.Join(message.buffer, "")
+	fields := message.fields
+
+	fields["stream"] = message.stream
+	fields["time"] = message.time
+
+	c.lastOffset = c.pendingOffset(message.endOffset)
+	c.callbackFunc(message.startOffset, c.lastOffset, text, fields)
+}
+
+// pendingOffset returns the given offset, or the start of the earliest message
+// still being reassembled if that is earlier
+func (c *containerLog) pendingOffset(offset int64) int64 {
+	for _, message := range c.messages {
+		if message.startOffset < offset {
+			offset = message.startOffset
+		}
+	}
+	return offset
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *containerLog) Meter() {
+	c.meterPartial = c.partialLines
+	c.meterFailed = c.failedLines
+	c.meterBytes = c.endOffset - c.lastOffset
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *containerLog) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("partial_lines", admin.APINumber(c.meterPartial))
+	api.SetEntry("failed_lines", admin.APINumber(c.meterFailed))
+	api.SetEntry("pending_bytes", admin.APINumber(c.meterBytes))
+	return api
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// criParseFailureTag is added to events whose line could not be decoded
+const criParseFailureTag = "_criparsefailure"
+
+// CodecCRIFactory holds the configuration for a cri codec
+type CodecCRIFactory struct {
+	MaxMessageBytes int64 `config:"max message bytes"`
+}
+
+// CodecCRI is an instance of a cri codec that is used by the Harvester to
+// unwrap lines written by container runtimes using the Kubernetes Container
+// Runtime Interface log format, such as containerd and CRI-O
+type CodecCRI struct {
+	containerLog
+}
+
+// NewCRICodecFactory creates a new CRICodecFactory for a codec definition in
+// the configuration file. This factory can be used to create instances of a
+// cri codec for use by harvesters
+func NewCRICodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecCRIFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.MaxMessageBytes, err = containerLogMaxBytes(config, result.MaxMessageBytes); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecCRIFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecCRI{
+		containerLog: newContainerLog(f.MaxMessageBytes, callbackFunc, offset),
+	}
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. Each
+// line has the form "<time> <stream> <tags> <message>", where the tags are
+// separated by colons and the first is "P" for a partial line or "F" for the
+// line that completes a message. The message is shipped, with the stream and
+// time as fields, once complete. Lines that fail to decode are shipped
+// unchanged with a "_criparsefailure" tag
+func (c *CodecCRI) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	parts := strings.SplitN(text, " ", 4)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" {
+		c.failed(startOffset, endOffset, text, fields, criParseFailureTag)
+		return
+	}
+
+	var partial bool
+	switch strings.SplitN(parts[2], ":", 2)[0] {
+	case "P":
+		partial = true
+	case "F":
+	default:
+		c.failed(startOffset, endOffset, text, fields, criParseFailureTag)
+		return
+	}
+
+	var message string
+	if len(parts) == 4 {
+		message = parts[3]
+	}
+
+	c.line(startOffset, endOffset, parts[1], parts[0], message, partial, fields)
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("cri", NewCRICodecFactory)
+}
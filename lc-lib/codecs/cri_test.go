@@ -0,0 +1,85 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createCRICodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+	config.General.SpoolMaxBytes = 10485760
+
+	factory, err := NewCRICodecFactory(config, "", unused, "cri")
+	if err != nil {
+		t.Errorf("Failed to create cri codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func TestCRI(t *testing.T) {
+	containerEvents = nil
+
+	codec := createCRICodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, "2015-03-04T05:06:07.123456789Z stdout F First line", core.Event{"path": "/var/log/containers/app.log"})
+	codec.Event(2, 3, "2015-03-04T05:06:08.000000000Z stderr F", nil)
+
+	if len(containerEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 0, 1, "First line", "stdout", "2015-03-04T05:06:07.123456789Z")
+	checkContainerEvent(t, 1, 2, 3, "", "stderr", "2015-03-04T05:06:08.000000000Z")
+
+	if containerEvents[0].fields["path"] != "/var/log/containers/app.log" {
+		t.Errorf("Existing field was lost: %v", containerEvents[0].fields)
+	}
+}
+
+func TestCRIPartial(t *testing.T) {
+	containerEvents = nil
+
+	codec := createCRICodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, "2015-03-04T05:06:07Z stdout P First ", nil)
+	codec.Event(2, 3, "2015-03-04T05:06:08Z stdout P long ", nil)
+
+	if len(containerEvents) != 0 {
+		t.Fatalf("Partial line was shipped: %v", containerEvents)
+	}
+
+	codec.Event(4, 5, "2015-03-04T05:06:09Z stdout F:extra line", nil)
+
+	if len(containerEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 0, 5, "First long line", "stdout", "2015-03-04T05:06:07Z")
+
+	if offset := codec.Teardown(); offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestCRIFailure(t *testing.T) {
+	containerEvents = nil
+
+	codec := createCRICodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, "Not CRI", nil)
+	codec.Event(2, 3, "2015-03-04T05:06:07Z stdout X Unknown tag", nil)
+
+	if len(containerEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	for _, event := range containerEvents {
+		if !hasTag(event.fields, "_criparsefailure") {
+			t.Errorf("Event missing failure tag: %v", event)
+		}
+	}
+}
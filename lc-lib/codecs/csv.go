@@ -0,0 +1,238 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultCSVDelimiter = ","
+
+	// csvParseFailureTag is added to events whose line could not be parsed
+	csvParseFailureTag = "_csvparsefailure"
+
+	// csvNoHeaderTag is added to events decoded in header mode when the header
+	// was not read because harvesting did not start at the beginning of the file
+	csvNoHeaderTag = "_csvnoheader"
+)
+
+var errCSVMultipleRecords = errors.New("Line contains more than one record")
+
+// CodecCSVFactory holds the configuration for a CSV codec
+type CodecCSVFactory struct {
+	Columns       []string `config:"columns"`
+	Delimiter     string   `config:"delimiter"`
+	Header        bool     `config:"header"`
+	OverwriteKeys bool     `config:"overwrite keys"`
+
+	delimiter rune
+}
+
+// CodecCSV is an instance of a CSV codec that is used by the Harvester to
+// decode delimited lines into event fields
+type CodecCSV struct {
+	config        *CodecCSVFactory
+	lastOffset    int64
+	columns       []string
+	headerSeen    bool
+	failedLines   uint64
+	noHeader      uint64
+	callbackFunc  CallbackFunc
+	meterFailed   uint64
+	meterNoHeader uint64
+}
+
+// NewCSVCodecFactory creates a new CSVCodecFactory for a codec definition in
+// the configuration file. This factory can be used to create instances of a
+// CSV codec for use by harvesters
+func NewCSVCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	result := &CodecCSVFactory{}
+	if err := config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if utf8.RuneCountInString(result.Delimiter) != 1 {
+		return nil, errors.New("CSV codec delimiter must be a single character.")
+	}
+	result.delimiter, _ = utf8.DecodeRuneInString(result.Delimiter)
+	if result.delimiter == '"' || result.delimiter == '\r' || result.delimiter == '\n' || result.delimiter == utf8.RuneError {
+		return nil, fmt.Errorf("Invalid CSV codec delimiter, '%s'.", result.Delimiter)
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for the CSV codec
+func (f *CodecCSVFactory) InitDefaults() {
+	f.Delimiter = defaultCSVDelimiter
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecCSVFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecCSV{
+		config:       f,
+		lastOffset:   offset,
+		columns:      f.Columns,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecCSV) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecCSV) Reset() {
+	c.lastOffset = 0
+	c.columns = c.config.Columns
+	c.headerSeen = false
+}
+
+// NeedsHeader returns true in header mode if the header has not been read, so
+// that the harvester passes it to SetHeader when starting part way through the
+// file
+func (c *CodecCSV) NeedsHeader() bool {
+	return c.config.Header && !c.headerSeen
+}
+
+// SetHeader reads the column names from the first line of the file in header
+// mode
+func (c *CodecCSV) SetHeader(text string) {
+	if !c.config.Header {
+		return
+	}
+
+	if record, err := c.parse(text); err == nil {
+		c.columns = record
+		c.headerSeen = true
+	}
+}
+
+// Flush is a no-op as the CSV codec never buffers events
+func (c *CodecCSV) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// values in the line are stored in the event fields named by the columns. In
+// header mode the first line of the file gives the column names and is not
+// shipped. If harvesting started part way through the file the harvester reads
+// the first line and passes it to SetHeader, and if that fails the lines are
+// tagged "_csvnoheader" as the columns are not known. Lines that can not be
+// parsed are shipped unchanged with a "_csvparsefailure" tag
+func (c *CodecCSV) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	record, err := c.parse(text)
+	if err != nil {
+		c.failedLines++
+		fields.AddTag(csvParseFailureTag)
+		c.callbackFunc(startOffset, endOffset, text, fields)
+		return
+	}
+
+	if c.config.Header {
+		if startOffset == 0 {
+			c.columns = record
+			c.headerSeen = true
+			return
+		}
+
+		if !c.headerSeen {
+			c.noHeader++
+			fields.AddTag(csvNoHeaderTag)
+		}
+	}
+
+	message := text
+	for i, value := range record {
+		name := c.column(i)
+		if name == "message" {
+			message = value
+			continue
+		}
+
+		setField(fields, name, value, c.config.OverwriteKeys)
+	}
+
+	c.callbackFunc(startOffset, endOffset, message, fields)
+}
+
+// parse reads the single record from the line
+func (c *CodecCSV) parse(text string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = c.config.delimiter
+	reader.FieldsPerRecord = -1
+
+	record, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = reader.Read(); err != io.EOF {
+		return nil, errCSVMultipleRecords
+	}
+
+	return record, nil
+}
+
+// column returns the field name for the column at the given index, which is
+// "column" followed by its number if it has no name
+func (c *CodecCSV) column(index int) string {
+	if index < len(c.columns) && c.columns[index] != "" {
+		return c.columns[index]
+	}
+
+	return fmt.Sprintf("column%d", index+1)
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecCSV) Meter() {
+	c.meterFailed = c.failedLines
+	c.meterNoHeader = c.noHeader
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecCSV) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("failed_lines", admin.APINumber(c.meterFailed))
+	if c.config.Header {
+		api.SetEntry("no_header_lines", admin.APINumber(c.meterNoHeader))
+	}
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("csv", NewCSVCodecFactory)
+}
@@ -0,0 +1,231 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type csvEvent struct {
+	start  int64
+	text   string
+	fields core.Event
+}
+
+var csvEvents []csvEvent
+
+func createCSVCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewCSVCodecFactory(config, "", unused, "csv")
+	if err != nil {
+		t.Errorf("Failed to create csv codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkCSV(startOffset int64, endOffset int64, text string, fields core.Event) {
+	csvEvents = append(csvEvents, csvEvent{startOffset, text, fields})
+}
+
+func checkCSVFields(t *testing.T, fields core.Event, expected map[string]interface{}) {
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("Wrong %s field received: %v (expected %v)", key, fields[key], value)
+		}
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	csvEvents = nil
+
+	codec := createCSVCodec(map[string]interface{}{
+		"columns": []string{"id", "", "message"},
+	}, checkCSV, t)
+
+	codec.Event(0, 1, `42,"quoted, value","The ""message""",extra`, nil)
+
+	if len(csvEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+
+	event := csvEvents[0]
+	if event.text != `The "message"` {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	checkCSVFields(t, event.fields, map[string]interface{}{
+		"id":      "42",
+		"column2": "quoted, value",
+		"column4": "extra",
+	})
+
+	offset := codec.Teardown()
+	if offset != 1 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	csvEvents = nil
+
+	codec := createCSVCodec(map[string]interface{}{
+		"delimiter": "\t",
+		"header":    true,
+	}, checkCSV, t)
+
+	codec.Event(0, 10, "name\tcount", nil)
+	codec.Event(11, 20, "apples\t3", nil)
+
+	if len(csvEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+
+	checkCSVFields(t, csvEvents[0].fields, map[string]interface{}{
+		"name":  "apples",
+		"count": "3",
+	})
+
+	// After truncation the header is read again
+	codec.Reset()
+	codec.Event(0, 10, "fruit\tnumber", nil)
+	codec.Event(11, 20, "pears\t5", nil)
+
+	if len(csvEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+
+	checkCSVFields(t, csvEvents[1].fields, map[string]interface{}{
+		"fruit":  "pears",
+		"number": "5",
+	})
+
+	offset := codec.Teardown()
+	if offset != 20 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestCSVHeaderResume(t *testing.T) {
+	csvEvents = nil
+
+	codec := createCSVCodec(map[string]interface{}{
+		"delimiter": "\t",
+		"header":    true,
+	}, checkCSV, t)
+
+	// Without the header from the harvester the columns are not known
+	codec.Event(11, 20, "apples\t3", nil)
+
+	if len(csvEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+
+	checkCSVFields(t, csvEvents[0].fields, map[string]interface{}{
+		"column1": "apples",
+		"column2": "3",
+	})
+	if !hasTag(csvEvents[0].fields, "_csvnoheader") {
+		t.Errorf("Line without a header was not tagged: %v", csvEvents[0].fields)
+	}
+
+	// The header passed by the harvester when resuming names the columns
+	if !NeedsHeader([]Codec{codec}) {
+		t.Fatal("Codec in header mode did not need the header")
+	}
+	SetHeader([]Codec{codec}, "fruit\tnumber")
+	codec.Event(21, 30, "limes\t4", nil)
+
+	if len(csvEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+	if hasTag(csvEvents[1].fields, "_csvnoheader") {
+		t.Errorf("Line after the header was set was tagged: %v", csvEvents[1].fields)
+	}
+	checkCSVFields(t, csvEvents[1].fields, map[string]interface{}{
+		"fruit":  "limes",
+		"number": "4",
+	})
+	if NeedsHeader([]Codec{codec}) {
+		t.Error("Codec needed the header once it was set")
+	}
+
+	// Once the header is read after truncation the tag is no longer added
+	csvEvents = csvEvents[:1]
+	codec.Reset()
+	codec.Event(0, 10, "name\tcount", nil)
+	codec.Event(11, 20, "pears\t5", nil)
+
+	if len(csvEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+	if hasTag(csvEvents[1].fields, "_csvnoheader") {
+		t.Errorf("Line after the header was tagged: %v", csvEvents[1].fields)
+	}
+	checkCSVFields(t, csvEvents[1].fields, map[string]interface{}{
+		"name":  "pears",
+		"count": "5",
+	})
+
+	// Configured columns are used in place of the header, but still tagged
+	csvEvents = nil
+	codec = createCSVCodec(map[string]interface{}{
+		"columns": []string{"fruit", "number"},
+		"header":  true,
+	}, checkCSV, t)
+
+	codec.Event(11, 20, "plums,7", nil)
+
+	if len(csvEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+	checkCSVFields(t, csvEvents[0].fields, map[string]interface{}{
+		"fruit":  "plums",
+		"number": "7",
+	})
+	if !hasTag(csvEvents[0].fields, "_csvnoheader") {
+		t.Errorf("Line without a header was not tagged: %v", csvEvents[0].fields)
+	}
+}
+
+func TestCSVParseFailure(t *testing.T) {
+	csvEvents = nil
+
+	codec := createCSVCodec(map[string]interface{}{
+		"columns": []string{"a", "b"},
+	}, checkCSV, t)
+
+	lines := []string{
+		`"unterminated,value`,
+		`bad"quote,value`,
+		``,
+	}
+	for i, line := range lines {
+		codec.Event(int64(i*2+1), int64(i*2+2), line, nil)
+	}
+
+	if len(csvEvents) != len(lines) {
+		t.Fatalf("Wrong event count received: %d", len(csvEvents))
+	}
+
+	for i, event := range csvEvents {
+		if event.text != lines[i] {
+			t.Errorf("Wrong message received for line %d: %s", i, event.text)
+		}
+		if !hasTag(event.fields, "_csvparsefailure") {
+			t.Errorf("Line %d was not tagged as a parse failure: %v", i, event.fields)
+		}
+	}
+}
+
+func TestCSVInvalidDelimiter(t *testing.T) {
+	config := config.NewConfig()
+
+	for _, delimiter := range []string{"", ",,", "\"", "\n"} {
+		if _, err := NewCSVCodecFactory(config, "", map[string]interface{}{"delimiter": delimiter}, "csv"); err == nil {
+			t.Errorf("Invalid delimiter was accepted: %q", delimiter)
+		}
+	}
+}
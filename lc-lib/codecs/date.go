@@ -0,0 +1,247 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultDateField    = "timestamp"
+	defaultDateTarget   = "@timestamp"
+	defaultDateTimezone = "Local"
+
+	// dateParseFailureTag is added to events whose time could not be parsed
+	dateParseFailureTag = "_dateparsefailure"
+)
+
+// dateLayouts are the named layouts that can be given in place of a Go time
+// layout
+var dateLayouts = map[string]string{
+	"ISO8601":  "2006-01-02T15:04:05.999999999Z07:00",
+	"RFC3339":  time.RFC3339Nano,
+	"RFC1123":  time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"SYSLOG":   "Jan _2 15:04:05",
+	"HTTPDATE": "02/Jan/2006:15:04:05 -0700",
+}
+
+// CodecDateFactory holds the configuration for a date codec
+type CodecDateFactory struct {
+	Field    string   `config:"field"`
+	Layouts  []string `config:"layouts"`
+	Target   string   `config:"target"`
+	Timezone string   `config:"timezone"`
+
+	location *time.Location
+}
+
+// CodecDate is an instance of a date codec that is used by the Harvester to
+// set the event timestamp from the time in the event
+type CodecDate struct {
+	config       *CodecDateFactory
+	lastOffset   int64
+	failedLines  uint64
+	callbackFunc CallbackFunc
+	meterFailed  uint64
+
+	now func() time.Time
+}
+
+// NewDateCodecFactory creates a new DateCodecFactory for a codec definition in
+// the configuration file. This factory can be used to create instances of a
+// date codec for use by harvesters
+func NewDateCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecDateFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Layouts) == 0 {
+		return nil, errors.New("Date codec layouts must be specified.")
+	}
+
+	if result.Field == "" {
+		return nil, errors.New("Date codec field can not be empty.")
+	}
+
+	if result.Target == "" {
+		return nil, errors.New("Date codec target can not be empty.")
+	}
+
+	if result.location, err = time.LoadLocation(result.Timezone); err != nil {
+		return nil, fmt.Errorf("Unknown date codec timezone, '%s': %s", result.Timezone, err)
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for the date codec
+func (f *CodecDateFactory) InitDefaults() {
+	f.Field = defaultDateField
+	f.Target = defaultDateTarget
+	f.Timezone = defaultDateTimezone
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecDateFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecDate{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+		now:          time.Now,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecDate) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecDate) Reset() {
+}
+
+// Flush is a no-op as the date codec never buffers events
+func (c *CodecDate) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// time in the configured field is parsed and stored in the target field.
+// Events where the time is missing or can not be parsed are shipped unchanged
+// with a "_dateparsefailure" tag
+func (c *CodecDate) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	var value interface{} = text
+	if c.config.Field != "message" {
+		value = fields[c.config.Field]
+	}
+
+	parsed, ok := c.parse(value)
+	if !ok {
+		c.failedLines++
+		fields.AddTag(dateParseFailureTag)
+		c.callbackFunc(startOffset, endOffset, text, fields)
+		return
+	}
+
+	fields[c.config.Target] = core.FormatTimestamp(parsed)
+
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// parse tries each of the layouts in turn against the value
+func (c *CodecDate) parse(value interface{}) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		// Such as a number decoded by the json codec
+		str = fmt.Sprint(value)
+	}
+
+	for _, layout := range c.config.Layouts {
+		if parsed, ok := c.parseLayout(str, layout); ok {
+			return parsed, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// parseLayout parses the value using a single layout
+func (c *CodecDate) parseLayout(value string, layout string) (time.Time, bool) {
+	switch layout {
+	case "UNIX":
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	case "UNIX_MS":
+		milliseconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, milliseconds*int64(time.Millisecond)), true
+	}
+
+	if named, ok := dateLayouts[layout]; ok {
+		layout = named
+	}
+
+	parsed, err := time.ParseInLocation(layout, value, c.config.location)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if parsed.Year() == 0 {
+		parsed = c.guessYear(parsed)
+	}
+
+	return parsed, true
+}
+
+// guessYear sets the year of a time parsed from a layout without one, such as
+// syslog timestamps. The current year is used unless that would place the time
+// more than a day in the future, in which case it must be from last year, such
+// as when reading December's logs in January
+func (c *CodecDate) guessYear(parsed time.Time) time.Time {
+	now := c.now().In(parsed.Location())
+
+	guess := parsed.AddDate(now.Year(), 0, 0)
+	if guess.After(now.Add(24 * time.Hour)) {
+		guess = parsed.AddDate(now.Year()-1, 0, 0)
+	}
+
+	return guess
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecDate) Meter() {
+	c.meterFailed = c.failedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecDate) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("failed_lines", admin.APINumber(c.meterFailed))
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("date", NewDateCodecFactory)
+}
@@ -0,0 +1,166 @@
+package codecs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var dateEvents []core.Event
+
+func createDateCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewDateCodecFactory(config, "", unused, "date")
+	if err != nil {
+		t.Errorf("Failed to create date codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkDate(startOffset int64, endOffset int64, text string, fields core.Event) {
+	dateEvents = append(dateEvents, fields)
+}
+
+func TestDate(t *testing.T) {
+	dateEvents = nil
+
+	codec := createDateCodec(map[string]interface{}{
+		"layouts":  []interface{}{"ISO8601", "2006-01-02 15:04:05"},
+		"timezone": "UTC",
+	}, checkDate, t)
+
+	codec.Event(0, 1, "First", core.Event{"timestamp": "2015-03-04T05:06:07.123+01:00"})
+	codec.Event(1, 2, "Second", core.Event{"timestamp": "2015-03-04 05:06:07"})
+
+	if len(dateEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(dateEvents))
+	}
+
+	if dateEvents[0]["@timestamp"] != "2015-03-04T04:06:07.123Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[0]["@timestamp"])
+	}
+
+	if dateEvents[1]["@timestamp"] != "2015-03-04T05:06:07.000Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[1]["@timestamp"])
+	}
+
+	if _, ok := dateEvents[0]["tags"]; ok {
+		t.Errorf("Unexpected tags received: %v", dateEvents[0]["tags"])
+	}
+}
+
+func TestDateMessage(t *testing.T) {
+	dateEvents = nil
+
+	codec := createDateCodec(map[string]interface{}{
+		"field":    "message",
+		"layouts":  []interface{}{"HTTPDATE"},
+		"target":   "logged_at",
+		"timezone": "UTC",
+	}, checkDate, t)
+
+	codec.Event(0, 1, "10/Oct/2000:13:55:36 -0700", nil)
+
+	if len(dateEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(dateEvents))
+	}
+
+	if dateEvents[0]["logged_at"] != "2000-10-10T20:55:36.000Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[0]["logged_at"])
+	}
+}
+
+func TestDateUnix(t *testing.T) {
+	dateEvents = nil
+
+	codec := createDateCodec(map[string]interface{}{
+		"layouts": []interface{}{"UNIX_MS", "UNIX"},
+	}, checkDate, t)
+
+	codec.Event(0, 1, "First", core.Event{"timestamp": "1425445567123"})
+	codec.Event(1, 2, "Second", core.Event{"timestamp": "1425445567.5"})
+
+	if len(dateEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(dateEvents))
+	}
+
+	if dateEvents[0]["@timestamp"] != "2015-03-04T05:06:07.123Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[0]["@timestamp"])
+	}
+
+	if dateEvents[1]["@timestamp"] != "2015-03-04T05:06:07.500Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[1]["@timestamp"])
+	}
+}
+
+func TestDateMissingYear(t *testing.T) {
+	dateEvents = nil
+
+	codec := createDateCodec(map[string]interface{}{
+		"layouts":  []interface{}{"SYSLOG"},
+		"timezone": "UTC",
+	}, checkDate, t)
+	codec.(*CodecDate).now = func() time.Time {
+		return time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+
+	codec.Event(0, 1, "First", core.Event{"timestamp": "Jan  2 03:00:00"})
+	codec.Event(1, 2, "Second", core.Event{"timestamp": "Dec 31 23:59:59"})
+
+	if len(dateEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(dateEvents))
+	}
+
+	if dateEvents[0]["@timestamp"] != "2015-01-02T03:00:00.000Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[0]["@timestamp"])
+	}
+
+	if dateEvents[1]["@timestamp"] != "2014-12-31T23:59:59.000Z" {
+		t.Errorf("Wrong timestamp received: %v", dateEvents[1]["@timestamp"])
+	}
+}
+
+func TestDateFailure(t *testing.T) {
+	dateEvents = nil
+
+	codec := createDateCodec(map[string]interface{}{
+		"layouts": []interface{}{"ISO8601"},
+	}, checkDate, t)
+
+	codec.Event(0, 1, "First", core.Event{"timestamp": "yesterday"})
+	codec.Event(1, 2, "Second", core.Event{"@timestamp": "2015-03-04T05:06:07.000Z"})
+
+	if len(dateEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(dateEvents))
+	}
+
+	for i, event := range dateEvents {
+		if !hasTag(event, "_dateparsefailure") {
+			t.Errorf("Event %d missing failure tag: %v", i, event)
+		}
+	}
+
+	if dateEvents[1]["@timestamp"] != "2015-03-04T05:06:07.000Z" {
+		t.Errorf("Timestamp was modified: %v", dateEvents[1]["@timestamp"])
+	}
+}
+
+func TestDateInvalidConfig(t *testing.T) {
+	config := config.NewConfig()
+
+	if _, err := NewDateCodecFactory(config, "", map[string]interface{}{}, "date"); err == nil {
+		t.Errorf("Missing layouts did not fail")
+	}
+
+	if _, err := NewDateCodecFactory(config, "", map[string]interface{}{
+		"layouts":  []interface{}{"ISO8601"},
+		"timezone": "Nowhere/Special",
+	}, "date"); err == nil {
+		t.Errorf("Invalid timezone did not fail")
+	}
+}
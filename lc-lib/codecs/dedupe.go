@@ -0,0 +1,303 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultDedupeCountField = "repeat_count"
+	defaultDedupeMaxPending = 100
+	defaultDedupeWindow     = 10 * time.Second
+)
+
+// CodecDedupeFactory holds the configuration for a dedupe codec
+type CodecDedupeFactory struct {
+	ConsecutiveOnly bool          `config:"consecutive only"`
+	CountField      string        `config:"count field"`
+	IgnorePatterns  []string      `config:"ignore patterns"`
+	MaxPending      int64         `config:"max pending"`
+	Window          time.Duration `config:"window"`
+
+	ignorePatterns []*regexp.Regexp
+}
+
+// dedupePending is a pending event and the number of lines collapsed into it
+type dedupePending struct {
+	startOffset int64
+	text        string
+	fields      core.Event
+	count       uint64
+}
+
+// CodecDedupe is an instance of a dedupe codec that is used by the Harvester
+// to collapse repeated lines into a single event
+type CodecDedupe struct {
+	config       *CodecDedupeFactory
+	lastOffset   int64
+	callbackFunc CallbackFunc
+
+	endOffset      int64
+	pending        []*dedupePending
+	pendingIndex   map[string]*dedupePending
+	pendingLines   uint64
+	collapsedLines uint64
+	timerLock      sync.Mutex
+	timerStop      chan interface{}
+	timerWait      sync.WaitGroup
+	timerDeadline  time.Time
+
+	meterCollapsed     uint64
+	meterPending       uint64
+	meterPendingEvents uint64
+}
+
+// NewDedupeCodecFactory creates a new DedupeCodecFactory for a codec
+// definition in the configuration file. This factory can be used to create
+// instances of a dedupe codec for use by harvesters
+func NewDedupeCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecDedupeFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Window <= 0 {
+		return nil, errors.New("Dedupe codec window must be greater than 0.")
+	}
+
+	if result.MaxPending <= 0 {
+		return nil, errors.New("Dedupe codec max pending must be greater than 0.")
+	}
+
+	result.ignorePatterns = make([]*regexp.Regexp, len(result.IgnorePatterns))
+	for k, pattern := range result.IgnorePatterns {
+		if result.ignorePatterns[k], err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("Failed to compile dedupe codec ignore pattern, '%s': %s", pattern, err)
+		}
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for the dedupe codec
+func (f *CodecDedupeFactory) InitDefaults() {
+	f.CountField = defaultDedupeCountField
+	f.MaxPending = defaultDedupeMaxPending
+	f.Window = defaultDedupeWindow
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecDedupeFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	c := &CodecDedupe{
+		config:       f,
+		endOffset:    offset,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+		pendingIndex: make(map[string]*dedupePending),
+	}
+
+	// Start the "window" routine that will auto flush at deadline
+	c.timerStop = make(chan interface{})
+	c.timerWait.Add(1)
+
+	c.timerDeadline = time.Now().Add(f.Window)
+
+	go c.deadlineRoutine()
+
+	return c
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback.
+// Lines collapsed into events that have not yet been shipped are after this
+// offset, so they will be read again if the file is resumed
+func (c *CodecDedupe) Teardown() int64 {
+	close(c.timerStop)
+	c.timerWait.Wait()
+
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecDedupe) Reset() {
+	c.lastOffset = 0
+	c.endOffset = 0
+	c.pending = nil
+	c.pendingIndex = make(map[string]*dedupePending)
+	c.pendingLines = 0
+}
+
+// Flush sends any pending events to the callback immediately
+func (c *CodecDedupe) Flush() {
+	c.timerLock.Lock()
+	defer c.timerLock.Unlock()
+
+	c.flush()
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. Lines
+// identical to a line seen within the window are collapsed into its event. In
+// consecutive only mode a line is only collapsed into the line before it, and
+// any other line ships the pending event
+func (c *CodecDedupe) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	// Prevent a flush happening while we're modifying the stored data
+	c.timerLock.Lock()
+	defer c.timerLock.Unlock()
+
+	compare := c.compareText(text)
+
+	if event, ok := c.pendingIndex[compare]; ok {
+		event.count++
+		c.pendingLines++
+		c.collapsedLines++
+		c.endOffset = endOffset
+		return
+	}
+
+	if c.config.ConsecutiveOnly || int64(len(c.pending)) >= c.config.MaxPending {
+		c.flush()
+	}
+
+	c.endOffset = endOffset
+
+	if len(c.pending) == 0 {
+		c.timerDeadline = time.Now().Add(c.config.Window)
+	}
+
+	event := &dedupePending{
+		startOffset: startOffset,
+		text:        text,
+		fields:      fields,
+		count:       1,
+	}
+	c.pending = append(c.pending, event)
+	c.pendingIndex[compare] = event
+	c.pendingLines++
+}
+
+// compareText returns the text of a line to compare against the pending lines,
+// with the parts matching the ignore patterns removed
+func (c *CodecDedupe) compareText(text string) string {
+	for _, pattern := range c.config.ignorePatterns {
+		text = pattern.ReplaceAllLiteralString(text, "")
+	}
+	return text
+}
+
+// flush is called internally when the pending events are ready. They are
+// shipped together in the order of their first lines, and their fields record
+// how many lines were collapsed into them. Repeated lines can come after the
+// first line of a later event, so each event ends where the next one starts
+// and the last ends after the last line read. This means the offset of the
+// last event is only reached once every line before it is shipped
+func (c *CodecDedupe) flush() {
+	if len(c.pending) == 0 {
+		return
+	}
+
+	pending := c.pending
+	endOffset := c.endOffset
+
+	c.pending = nil
+	c.pendingIndex = make(map[string]*dedupePending)
+	c.pendingLines = 0
+
+	for i, event := range pending {
+		fields := event.fields
+		if event.count > 1 && c.config.CountField != "" {
+			if fields == nil {
+				fields = core.Event{}
+			}
+			fields[c.config.CountField] = event.count
+		}
+
+		eventEnd := endOffset
+		if i+1 < len(pending) {
+			eventEnd = pending[i+1].startOffset
+		}
+
+		// Set last offset - this is returned in Teardown so if we're mid collapse
+		// and crash, we start the collapse again
+		c.lastOffset = eventEnd
+
+		c.callbackFunc(event.startOffset, eventEnd, event.text, fields)
+	}
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecDedupe) Meter() {
+	c.meterCollapsed = c.collapsedLines
+	c.meterPending = c.pendingLines
+	c.meterPendingEvents = uint64(len(c.pending))
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecDedupe) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("collapsed_lines", admin.APINumber(c.meterCollapsed))
+	api.SetEntry("pending_lines", admin.APINumber(c.meterPending))
+	api.SetEntry("pending_events", admin.APINumber(c.meterPendingEvents))
+	return api
+}
+
+func (c *CodecDedupe) deadlineRoutine() {
+	timer := time.NewTimer(0)
+
+DeadlineLoop:
+	for {
+		select {
+		case <-c.timerStop:
+			timer.Stop()
+
+			// Shutdown signal so end the routine
+			break DeadlineLoop
+		case now := <-timer.C:
+			c.timerLock.Lock()
+
+			// Have we reached the target time?
+			if !now.After(c.timerDeadline) {
+				// Deadline moved, update the timer
+				timer.Reset(c.timerDeadline.Sub(now))
+				c.timerLock.Unlock()
+				continue
+			}
+
+			c.flush()
+			timer.Reset(c.config.Window)
+			c.timerLock.Unlock()
+		}
+	}
+
+	c.timerWait.Done()
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("dedupe", NewDedupeCodecFactory)
+}
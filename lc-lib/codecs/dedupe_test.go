@@ -0,0 +1,233 @@
+package codecs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type dedupeEvent struct {
+	start, end int64
+	text       string
+	fields     core.Event
+}
+
+type checkDedupe struct {
+	mutex  sync.Mutex
+	events []dedupeEvent
+}
+
+func (c *checkDedupe) EventCallback(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events = append(c.events, dedupeEvent{startOffset, endOffset, text, fields})
+}
+
+func (c *checkDedupe) Events() []dedupeEvent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]dedupeEvent(nil), c.events...)
+}
+
+func (c *checkDedupe) Check(t *testing.T, expect []dedupeEvent) {
+	events := c.Events()
+	if len(events) != len(expect) {
+		t.Fatalf("Wrong event count received: %d != %d", len(events), len(expect))
+	}
+
+	for i, event := range events {
+		if event.start != expect[i].start || event.end != expect[i].end {
+			t.Errorf("Wrong offsets for event %d: %d-%d", i, event.start, event.end)
+		}
+		if event.text != expect[i].text {
+			t.Errorf("Wrong text for event %d: %s", i, event.text)
+		}
+		if event.fields["repeat_count"] != expect[i].fields["repeat_count"] {
+			t.Errorf("Wrong repeat count for event %d: %v", i, event.fields["repeat_count"])
+		}
+	}
+}
+
+func createDedupeCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewDedupeCodecFactory(config, "", unused, "dedupe")
+	if err != nil {
+		t.Errorf("Failed to create dedupe codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func TestDedupe(t *testing.T) {
+	check := &checkDedupe{}
+
+	codec := createDedupeCodec(map[string]interface{}{
+		"consecutive only": true,
+	}, check.EventCallback, t)
+
+	codec.Event(0, 1, "First line", nil)
+	codec.Event(2, 3, "Repeated line", nil)
+	codec.Event(4, 5, "Repeated line", nil)
+	codec.Event(6, 7, "Repeated line", nil)
+	codec.Event(8, 9, "Last line", nil)
+
+	check.Check(t, []dedupeEvent{
+		{0, 1, "First line", nil},
+		{2, 7, "Repeated line", core.Event{"repeat_count": uint64(3)}},
+	})
+
+	// The pending line has not been shipped so must be read again on resume
+	if offset := codec.Teardown(); offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeNonConsecutive(t *testing.T) {
+	check := &checkDedupe{}
+
+	codec := createDedupeCodec(map[string]interface{}{}, check.EventCallback, t)
+
+	codec.Event(0, 1, "Line A", nil)
+	codec.Event(2, 3, "Line B", nil)
+	codec.Event(4, 5, "Line A", nil)
+	codec.Event(6, 7, "Line C", nil)
+	codec.Event(8, 9, "Line B", nil)
+	codec.Event(10, 11, "Line A", nil)
+
+	if len(check.Events()) != 0 {
+		t.Fatalf("Events were shipped before the window ended")
+	}
+
+	codec.Flush()
+
+	// Each event ends where the next starts so the last offset is only reached
+	// when all of them are shipped
+	check.Check(t, []dedupeEvent{
+		{0, 2, "Line A", core.Event{"repeat_count": uint64(3)}},
+		{2, 6, "Line B", core.Event{"repeat_count": uint64(2)}},
+		{6, 11, "Line C", nil},
+	})
+
+	if offset := codec.Teardown(); offset != 11 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeMaxPending(t *testing.T) {
+	check := &checkDedupe{}
+
+	codec := createDedupeCodec(map[string]interface{}{
+		"max pending": 2,
+	}, check.EventCallback, t)
+
+	codec.Event(0, 1, "Line A", nil)
+	codec.Event(2, 3, "Line B", nil)
+	codec.Event(4, 5, "Line A", nil)
+	codec.Event(6, 7, "Line C", nil)
+
+	check.Check(t, []dedupeEvent{
+		{0, 2, "Line A", core.Event{"repeat_count": uint64(2)}},
+		{2, 5, "Line B", nil},
+	})
+
+	if offset := codec.Teardown(); offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeInvalidWindow(t *testing.T) {
+	for _, window := range []string{"0s", "-1s"} {
+		_, err := NewDedupeCodecFactory(config.NewConfig(), "", map[string]interface{}{
+			"window": window,
+		}, "dedupe")
+		if err == nil {
+			t.Errorf("Window of %s did not fail", window)
+		}
+	}
+}
+
+func TestDedupeIgnorePatterns(t *testing.T) {
+	check := &checkDedupe{}
+
+	codec := createDedupeCodec(map[string]interface{}{
+		"ignore patterns": []string{`^[0-9:.]+ `},
+	}, check.EventCallback, t)
+
+	codec.Event(0, 1, "05:06:07.123 Connection refused", core.Event{"offset": int64(0)})
+	codec.Event(2, 3, "05:06:07.456 Connection refused", core.Event{"offset": int64(2)})
+	codec.Event(4, 5, "05:06:08.000 Connected", nil)
+	codec.Flush()
+
+	check.Check(t, []dedupeEvent{
+		{0, 4, "05:06:07.123 Connection refused", core.Event{"repeat_count": uint64(2)}},
+		{4, 5, "05:06:08.000 Connected", nil},
+	})
+
+	// The event takes the fields of its first line
+	if offset := check.Events()[0].fields["offset"]; offset != int64(0) {
+		t.Errorf("Wrong fields for collapsed event: %v", check.Events()[0].fields)
+	}
+
+	if offset := codec.Teardown(); offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeWindow(t *testing.T) {
+	check := &checkDedupe{}
+
+	codec := createDedupeCodec(map[string]interface{}{
+		"window": "2s",
+	}, check.EventCallback, t)
+
+	codec.Event(0, 1, "Repeated line", nil)
+	codec.Event(2, 3, "Repeated line", nil)
+
+	// Allow a second
+	time.Sleep(time.Second)
+
+	if len(check.Events()) != 0 {
+		t.Fatalf("Window expired too early")
+	}
+
+	// Allow 3 seconds
+	time.Sleep(3 * time.Second)
+
+	check.Check(t, []dedupeEvent{
+		{0, 3, "Repeated line", core.Event{"repeat_count": uint64(2)}},
+	})
+
+	// Repeats after the window start a new event
+	codec.Event(4, 5, "Repeated line", nil)
+	codec.Flush()
+
+	check.Check(t, []dedupeEvent{
+		{0, 3, "Repeated line", core.Event{"repeat_count": uint64(2)}},
+		{4, 5, "Repeated line", nil},
+	})
+
+	if offset := codec.Teardown(); offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDedupeReset(t *testing.T) {
+	check := &checkDedupe{}
+
+	codec := createDedupeCodec(map[string]interface{}{}, check.EventCallback, t)
+
+	codec.Event(0, 1, "Repeated line", nil)
+	codec.Event(2, 3, "Repeated line", nil)
+	codec.Reset()
+	codec.Event(0, 1, "Repeated line", nil)
+	codec.Flush()
+
+	check.Check(t, []dedupeEvent{
+		{0, 1, "Repeated line", nil},
+	})
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// dockerParseFailureTag is added to events whose line could not be decoded
+const dockerParseFailureTag = "_dockerparsefailure"
+
+// CodecDockerFactory holds the configuration for a docker codec
+type CodecDockerFactory struct {
+	MaxMessageBytes int64 `config:"max message bytes"`
+}
+
+// CodecDocker is an instance of a docker codec that is used by the Harvester to
+// unwrap lines written by the Docker json-file logging driver
+type CodecDocker struct {
+	containerLog
+}
+
+// dockerLine is a single line written by the Docker json-file logging driver
+type dockerLine struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+	Time   string  `json:"time"`
+}
+
+// NewDockerCodecFactory creates a new DockerCodecFactory for a codec definition
+// in the configuration file. This factory can be used to create instances of a
+// docker codec for use by harvesters
+func NewDockerCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecDockerFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.MaxMessageBytes, err = containerLogMaxBytes(config, result.MaxMessageBytes); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecDockerFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecDocker{
+		containerLog: newContainerLog(f.MaxMessageBytes, callbackFunc, offset),
+	}
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// line is decoded and the message it contains is shipped, with the stream and
+// time as fields. Docker splits long messages into lines of 16k that do not end
+// with a new line, and these are reassembled. Lines that fail to decode are
+// shipped unchanged with a "_dockerparsefailure" tag
+func (c *CodecDocker) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	var decoded dockerLine
+	if err := json.Unmarshal([]byte(text), &decoded); err != nil || decoded.Log == nil {
+		c.failed(startOffset, endOffset, text, fields, dockerParseFailureTag)
+		return
+	}
+
+	message := *decoded.Log
+	partial := !strings.HasSuffix(message, "\n")
+	if !partial {
+		message = strings.TrimSuffix(message[:len(message)-1], "\r")
+	}
+
+	c.line(startOffset, endOffset, decoded.Stream, decoded.Time, message, partial, fields)
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("docker", NewDockerCodecFactory)
+}
@@ -0,0 +1,193 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type containerEvent struct {
+	start, end int64
+	text       string
+	fields     core.Event
+}
+
+var containerEvents []containerEvent
+
+func createDockerCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+	config.General.SpoolMaxBytes = 10485760
+
+	factory, err := NewDockerCodecFactory(config, "", unused, "docker")
+	if err != nil {
+		t.Errorf("Failed to create docker codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkContainer(startOffset int64, endOffset int64, text string, fields core.Event) {
+	containerEvents = append(containerEvents, containerEvent{startOffset, endOffset, text, fields})
+}
+
+func checkContainerEvent(t *testing.T, index int, start, end int64, text string, stream string, time string) {
+	if index >= len(containerEvents) {
+		t.Errorf("Event %d not received", index)
+		return
+	}
+
+	event := containerEvents[index]
+	if event.start != start || event.end != end {
+		t.Errorf("Wrong offsets for event %d: %d-%d", index, event.start, event.end)
+	}
+	if event.text != text {
+		t.Errorf("Wrong message for event %d: %s", index, event.text)
+	}
+	if event.fields["stream"] != stream {
+		t.Errorf("Wrong stream for event %d: %v", index, event.fields["stream"])
+	}
+	if event.fields["time"] != time {
+		t.Errorf("Wrong time for event %d: %v", index, event.fields["time"])
+	}
+}
+
+func TestDocker(t *testing.T) {
+	containerEvents = nil
+
+	codec := createDockerCodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, `{"log":"First line\n","stream":"stdout","time":"2015-03-04T05:06:07.123456789Z"}`, core.Event{"path": "/var/log/containers/app.log"})
+	codec.Event(2, 3, `{"log":"Second line\r\n","stream":"stderr","time":"2015-03-04T05:06:08.000000000Z"}`, nil)
+
+	if len(containerEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 0, 1, "First line", "stdout", "2015-03-04T05:06:07.123456789Z")
+	checkContainerEvent(t, 1, 2, 3, "Second line", "stderr", "2015-03-04T05:06:08.000000000Z")
+
+	if containerEvents[0].fields["path"] != "/var/log/containers/app.log" {
+		t.Errorf("Existing field was lost: %v", containerEvents[0].fields)
+	}
+
+	if offset := codec.Teardown(); offset != 3 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDockerPartial(t *testing.T) {
+	containerEvents = nil
+
+	codec := createDockerCodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, `{"log":"First ","stream":"stdout","time":"2015-03-04T05:06:07Z"}`, nil)
+	codec.Event(2, 3, `{"log":"long ","stream":"stdout","time":"2015-03-04T05:06:08Z"}`, nil)
+
+	if len(containerEvents) != 0 {
+		t.Fatalf("Partial line was shipped: %v", containerEvents)
+	}
+
+	if offset := codec.Teardown(); offset != 0 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+
+	codec.Event(4, 5, `{"log":"line\n","stream":"stdout","time":"2015-03-04T05:06:09Z"}`, nil)
+
+	// A partial line is shipped incomplete when flushed
+	codec.Event(6, 7, `{"log":"Incomplete","stream":"stdout","time":"2015-03-04T05:06:10Z"}`, nil)
+	codec.Flush()
+
+	if len(containerEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 0, 5, "First long line", "stdout", "2015-03-04T05:06:07Z")
+	checkContainerEvent(t, 1, 6, 7, "Incomplete", "stdout", "2015-03-04T05:06:10Z")
+}
+
+func TestDockerInterleaved(t *testing.T) {
+	containerEvents = nil
+
+	codec := createDockerCodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, `{"log":"First ","stream":"stdout","time":"2015-03-04T05:06:07Z"}`, nil)
+	codec.Event(2, 3, `{"log":"Error ","stream":"stderr","time":"2015-03-04T05:06:08Z"}`, nil)
+	codec.Event(4, 5, `{"log":"long ","stream":"stdout","time":"2015-03-04T05:06:09Z"}`, nil)
+
+	if len(containerEvents) != 0 {
+		t.Fatalf("Partial line was shipped: %v", containerEvents)
+	}
+
+	// The stream that started first is resumed from
+	if offset := codec.Teardown(); offset != 0 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+
+	// Messages completed while another stream has a partial message end at
+	// the start of that message
+	codec.Event(6, 7, `{"log":"message\n","stream":"stderr","time":"2015-03-04T05:06:10Z"}`, nil)
+
+	if offset := codec.Teardown(); offset != 0 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+
+	codec.Event(8, 9, `{"log":"line\n","stream":"stdout","time":"2015-03-04T05:06:11Z"}`, nil)
+	codec.Event(10, 11, `{"log":"Another ","stream":"stderr","time":"2015-03-04T05:06:12Z"}`, nil)
+
+	if len(containerEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 2, 0, "Error message", "stderr", "2015-03-04T05:06:08Z")
+	checkContainerEvent(t, 1, 0, 9, "First long line", "stdout", "2015-03-04T05:06:07Z")
+
+	if offset := codec.Teardown(); offset != 9 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestDockerMaxMessageBytes(t *testing.T) {
+	containerEvents = nil
+
+	codec := createDockerCodec(map[string]interface{}{"max message bytes": int64(8)}, checkContainer, t)
+
+	codec.Event(0, 1, `{"log":"12345","stream":"stdout","time":"2015-03-04T05:06:07Z"}`, nil)
+	codec.Event(2, 3, `{"log":"67890","stream":"stdout","time":"2015-03-04T05:06:08Z"}`, nil)
+	codec.Event(4, 5, `{"log":"abc","stream":"stdout","time":"2015-03-04T05:06:09Z"}`, nil)
+	codec.Flush()
+
+	if len(containerEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 0, 3, "1234567890", "stdout", "2015-03-04T05:06:07Z")
+	checkContainerEvent(t, 1, 4, 5, "abc", "stdout", "2015-03-04T05:06:09Z")
+}
+
+func TestDockerFailure(t *testing.T) {
+	containerEvents = nil
+
+	codec := createDockerCodec(map[string]interface{}{}, checkContainer, t)
+
+	codec.Event(0, 1, `{"log":"Partial","stream":"stdout","time":"2015-03-04T05:06:07Z"}`, nil)
+	codec.Event(2, 3, `Not JSON`, nil)
+	codec.Event(4, 5, `{"message":"Not docker"}`, nil)
+
+	if len(containerEvents) != 3 {
+		t.Fatalf("Wrong event count received: %d", len(containerEvents))
+	}
+
+	checkContainerEvent(t, 0, 0, 1, "Partial", "stdout", "2015-03-04T05:06:07Z")
+
+	for _, event := range containerEvents[1:] {
+		if !hasTag(event.fields, "_dockerparsefailure") {
+			t.Errorf("Event missing failure tag: %v", event)
+		}
+	}
+
+	if containerEvents[1].text != "Not JSON" {
+		t.Errorf("Wrong message received: %s", containerEvents[1].text)
+	}
+}
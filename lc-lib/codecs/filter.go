@@ -18,16 +18,38 @@ package codecs
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
+const (
+	filterModeDrop = iota
+	filterModeTag
+)
+
+// CodecFilterRule holds the configuration for a filter codec rule, which adds
+// tags and fields to lines matching a single pattern
+type CodecFilterRule struct {
+	Fields  map[string]interface{} `config:"fields"`
+	Pattern string                 `config:"pattern"`
+	Tags    []string               `config:"tags"`
+
+	pattern PatternCollection
+}
+
 // CodecFilterFactory holds the configuration for a filter codec
 type CodecFilterFactory struct {
-	Patterns []string `config:"patterns"`
-	Match    string   `config:"match"`
-
+	Patterns []string               `config:"patterns"`
+	Match    string                 `config:"match"`
+	Mode     string                 `config:"mode"`
+	Fields   map[string]interface{} `config:"fields"`
+	Tags     []string               `config:"tags"`
+	Rules    []CodecFilterRule      `config:"rules"`
+
+	mode            int
 	patterns        PatternCollection
 	requiredMatches int
 }
@@ -38,8 +60,10 @@ type CodecFilter struct {
 	config        *CodecFilterFactory
 	lastOffset    int64
 	filteredLines uint64
+	taggedLines   uint64
 	callbackFunc  CallbackFunc
 	meterFiltered uint64
+	meterTagged   uint64
 }
 
 // NewFilterCodecFactory creates a new FilterCodecFactory for a codec definition
@@ -53,17 +77,74 @@ func NewFilterCodecFactory(config *config.Config, configPath string, unused map[
 		return nil, err
 	}
 
-	if len(result.Patterns) == 0 {
+	switch result.Mode {
+	case "", "drop":
+		result.mode = filterModeDrop
+	case "tag":
+		result.mode = filterModeTag
+	default:
+		return nil, fmt.Errorf("Unknown \"mode\" value for filter codec, '%s'.", result.Mode)
+	}
+
+	if len(result.Patterns) != 0 {
+		if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
+			return nil, err
+		}
+
+		if result.mode == filterModeTag && len(result.Tags) == 0 && len(result.Fields) == 0 {
+			return nil, errors.New("Filter codec requires tags or fields to be specified when patterns are used in tag mode.")
+		}
+	} else if result.mode == filterModeDrop {
 		return nil, errors.New("Filter codec pattern must be specified.")
+	} else if len(result.Rules) == 0 {
+		return nil, errors.New("Filter codec requires one of patterns or rules to be specified in tag mode.")
 	}
 
-	if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
+	if err = config.FixMapKeys(configPath+"fields", result.Fields); err != nil {
 		return nil, err
 	}
+	if _, ok := result.Fields["tags"]; ok {
+		return nil, errors.New("Filter codec fields can not contain tags, use the tags option instead.")
+	}
+
+	for k := range result.Rules {
+		if err = result.initRule(config, fmt.Sprintf("%srules[%d]/", configPath, k), k); err != nil {
+			return nil, err
+		}
+	}
 
 	return result, nil
 }
 
+// initRule validates and compiles a single rule
+func (f *CodecFilterFactory) initRule(config *config.Config, configPath string, k int) error {
+	rule := &f.Rules[k]
+
+	if rule.Pattern == "" {
+		return fmt.Errorf("Filter codec rule %d requires a pattern to be specified.", k)
+	}
+
+	if len(rule.Tags) == 0 && len(rule.Fields) == 0 {
+		return fmt.Errorf("Filter codec rule %d requires tags or fields to be specified.", k)
+	}
+
+	if err := rule.pattern.Set([]string{rule.Pattern}, "any"); err != nil {
+		return err
+	}
+
+	if err := config.FixMapKeys(configPath+"fields", rule.Fields); err != nil {
+		return err
+	}
+
+	// Tags in fields would replace the tags of the event instead of adding to
+	// them
+	if _, ok := rule.Fields["tags"]; ok {
+		return fmt.Errorf("Filter codec rule %d fields can not contain tags, use the tags option instead.", k)
+	}
+
+	return nil
+}
+
 // NewCodec returns a new codec instance that will send events to the callback
 // function provided upon completion of processing
 func (f *CodecFilterFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
@@ -84,30 +165,70 @@ func (c *CodecFilter) Teardown() int64 {
 func (c *CodecFilter) Reset() {
 }
 
+// Flush is a no-op as the filter codec never buffers events
+func (c *CodecFilter) Flush() {
+}
+
 // Event is called by a Harvester when a new line event occurs on a file.
-// Filtering takes place and only accepted lines are shipped to the callback
-func (c *CodecFilter) Event(startOffset int64, endOffset int64, text string) {
-	// Only flush the event if it matches
-	matched := c.config.patterns.Match(text)
+// Filtering takes place and only accepted lines are shipped to the callback. In
+// tag mode all lines are shipped, and the patterns only decide which lines
+// receive the configured tags and fields
+func (c *CodecFilter) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
 
-	if matched {
-		c.callbackFunc(startOffset, endOffset, text)
-	} else {
+	matched := len(c.config.Patterns) != 0 && c.config.patterns.Match(text)
+	if !matched && c.config.mode == filterModeDrop {
 		c.filteredLines++
+		return
 	}
 
-	c.lastOffset = endOffset
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	var tagged bool
+	if matched {
+		tagged = c.apply(fields, c.config.Tags, c.config.Fields)
+	}
+
+	for k := range c.config.Rules {
+		rule := &c.config.Rules[k]
+		if rule.pattern.Match(text) && c.apply(fields, rule.Tags, rule.Fields) {
+			tagged = true
+		}
+	}
+
+	if tagged {
+		c.taggedLines++
+	}
+
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// apply adds tags and fields to an event, returning true if there were any
+func (c *CodecFilter) apply(fields core.Event, tags []string, extraFields map[string]interface{}) bool {
+	for k, v := range extraFields {
+		fields[k] = v
+	}
+
+	for _, tag := range tags {
+		fields.AddTag(tag)
+	}
+
+	return len(tags) != 0 || len(extraFields) != 0
 }
 
 // Meter is called by the Harvester to request accounting
 func (c *CodecFilter) Meter() {
 	c.meterFiltered = c.filteredLines
+	c.meterTagged = c.taggedLines
 }
 
 // APIEncodable is called to get the codec status for the API
 func (c *CodecFilter) APIEncodable() admin.APIEncodable {
 	api := &admin.APIKeyValue{}
 	api.SetEntry("filtered_lines", admin.APINumber(c.meterFiltered))
+	api.SetEntry("tagged_lines", admin.APINumber(c.meterTagged))
 	return api
 }
 
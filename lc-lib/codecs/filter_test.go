@@ -3,7 +3,9 @@ package codecs
 import (
 	"testing"
 
+	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 var filterLines []string
@@ -20,7 +22,7 @@ func createFilterCodec(unused map[string]interface{}, callback CallbackFunc, t *
 	return NewCodec(factory, callback, 0)
 }
 
-func checkFilter(startOffset int64, endOffset int64, text string) {
+func checkFilter(startOffset int64, endOffset int64, text string, fields core.Event) {
 	filterLines = append(filterLines, text)
 }
 
@@ -32,10 +34,10 @@ func TestFilter(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	if len(filterLines) != 1 {
 		t.Error("Wrong line count received")
@@ -57,10 +59,10 @@ func TestFilterNegate(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	if len(filterLines) != 3 {
 		t.Error("Wrong line count received")
@@ -86,10 +88,10 @@ func TestFilterMultiple(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	if len(filterLines) != 2 {
 		t.Error("Wrong line count received")
@@ -114,10 +116,10 @@ func TestFilterMultipleAll(t *testing.T) {
 	}, checkFilter, t)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line DEBUG another line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line DEBUG another line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	if len(filterLines) != 1 {
 		t.Error("Wrong line count received")
@@ -130,3 +132,107 @@ func TestFilterMultipleAll(t *testing.T) {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
+
+var filterEvents []jsonEvent
+
+func checkFilterEvent(startOffset int64, endOffset int64, text string, fields core.Event) {
+	filterEvents = append(filterEvents, jsonEvent{text, fields})
+}
+
+func TestFilterTagMode(t *testing.T) {
+	filterEvents = nil
+
+	codec := createFilterCodec(map[string]interface{}{
+		"mode":     "tag",
+		"patterns": []interface{}{"ERROR"},
+		"tags":     []interface{}{"error"},
+		"fields":   map[string]interface{}{"severity": "high"},
+	}, checkFilterEvent, t)
+
+	codec.Event(0, 1, "INFO First line", core.Event{})
+	codec.Event(2, 3, "ERROR Second line", core.Event{})
+	codec.Event(4, 5, "INFO Third line", nil)
+
+	if len(filterEvents) != 3 {
+		t.Fatalf("Wrong event count received: %d", len(filterEvents))
+	}
+
+	if _, ok := filterEvents[0].fields["tags"]; ok {
+		t.Errorf("Unexpected tags on event[0]: %v", filterEvents[0].fields["tags"])
+	}
+	if !hasTag(filterEvents[1].fields, "error") {
+		t.Errorf("Missing tag on event[1]: %v", filterEvents[1].fields)
+	}
+	if filterEvents[1].fields["severity"] != "high" {
+		t.Errorf("Missing field on event[1]: %v", filterEvents[1].fields)
+	}
+	if _, ok := filterEvents[2].fields["severity"]; ok {
+		t.Errorf("Unexpected field on event[2]: %v", filterEvents[2].fields)
+	}
+
+	offset := codec.Teardown()
+	if offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	filterEvents = nil
+
+	codec := createFilterCodec(map[string]interface{}{
+		"patterns": []interface{}{"!^DEBUG"},
+		"rules": []interface{}{
+			map[string]interface{}{"pattern": "timeout", "tags": []interface{}{"slow"}},
+			map[string]interface{}{"pattern": "^ERROR", "tags": []interface{}{"error", "slow"}, "fields": map[string]interface{}{"alert": true}},
+		},
+	}, checkFilterEvent, t)
+
+	codec.Event(0, 1, "DEBUG timeout", core.Event{})
+	codec.Event(2, 3, "ERROR Request timeout", core.Event{"tags": []string{"splitline"}})
+	codec.Event(4, 5, "WARN Request timeout", core.Event{"tags": "configured"})
+
+	if len(filterEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(filterEvents))
+	}
+
+	tags, ok := filterEvents[0].fields["tags"].([]string)
+	if !ok || len(tags) != 3 || tags[0] != "splitline" || tags[1] != "slow" || tags[2] != "error" {
+		t.Errorf("Wrong tags on event[0]: %v", filterEvents[0].fields["tags"])
+	}
+	if filterEvents[0].fields["alert"] != true {
+		t.Errorf("Missing field on event[0]: %v", filterEvents[0].fields)
+	}
+
+	tags, ok = filterEvents[1].fields["tags"].([]string)
+	if !ok || len(tags) != 2 || tags[0] != "configured" || tags[1] != "slow" {
+		t.Errorf("Wrong tags on event[1]: %v", filterEvents[1].fields["tags"])
+	}
+
+	codec.Meter()
+	encoded, err := codec.APIEncodable().(*admin.APIKeyValue).MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to encode API: %s", err)
+	}
+	if string(encoded) != `{"filtered_lines":1,"tagged_lines":2}` {
+		t.Errorf("Wrong API received: %s", encoded)
+	}
+}
+
+func TestFilterInvalidConfig(t *testing.T) {
+	config := config.NewConfig()
+
+	for _, unused := range []map[string]interface{}{
+		{},
+		{"mode": "tag"},
+		{"mode": "unknown", "patterns": []interface{}{"ERROR"}},
+		{"mode": "tag", "patterns": []interface{}{"ERROR"}},
+		{"mode": "tag", "rules": []interface{}{map[string]interface{}{"tags": []interface{}{"error"}}}},
+		{"mode": "tag", "rules": []interface{}{map[string]interface{}{"pattern": "ERROR"}}},
+		{"patterns": []interface{}{"ERROR"}, "fields": map[string]interface{}{"tags": "error"}},
+		{"mode": "tag", "rules": []interface{}{map[string]interface{}{"pattern": "ERROR", "fields": map[string]interface{}{"tags": []interface{}{"error"}}}}},
+	} {
+		if _, err := NewFilterCodecFactory(config, "", unused, "filter"); err == nil {
+			t.Errorf("Invalid configuration did not fail: %v", unused)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	// grokParseFailureTag is added to events whose line did not match
+	grokParseFailureTag = "_grokparsefailure"
+
+	// grokMaxDepth limits how deeply pattern references can be nested, which
+	// catches patterns that refer to themselves
+	grokMaxDepth = 32
+)
+
+var (
+	// grokReference matches a reference to a named pattern in the form
+	// %{NAME}, %{NAME:field} or %{NAME:field:type}
+	grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(\w+))?\}`)
+
+	grokPatternName = regexp.MustCompile(`^\w+$`)
+)
+
+// grokField describes the event field that a capture group generated from a
+// pattern reference is stored in
+type grokField struct {
+	name       string
+	conversion string
+}
+
+// CodecGrokFactory holds the configuration for a grok codec
+type CodecGrokFactory struct {
+	CustomPatterns map[string]string `config:"custom patterns"`
+	Match          string            `config:"match"`
+	OverwriteKeys  bool              `config:"overwrite keys"`
+	Patterns       []string          `config:"patterns"`
+
+	patterns PatternCollection
+	library  map[string]string
+	fields   map[string]*grokField
+}
+
+// CodecGrok is an instance of a grok codec that is used by the Harvester to
+// extract event fields from lines
+type CodecGrok struct {
+	config       *CodecGrokFactory
+	lastOffset   int64
+	matchedLines uint64
+	missedLines  uint64
+	callbackFunc CallbackFunc
+	meterMatched uint64
+	meterMissed  uint64
+}
+
+// NewGrokCodecFactory creates a new GrokCodecFactory for a codec definition in
+// the configuration file. This factory can be used to create instances of a
+// grok codec for use by harvesters
+func NewGrokCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecGrokFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Patterns) == 0 {
+		return nil, errors.New("Grok codec pattern must be specified.")
+	}
+
+	result.library = make(map[string]string, len(grokPatterns)+len(result.CustomPatterns))
+	for name, pattern := range grokPatterns {
+		result.library[name] = pattern
+	}
+	for name, pattern := range result.CustomPatterns {
+		if !grokPatternName.MatchString(name) {
+			return nil, fmt.Errorf("Invalid grok custom pattern name, '%s'.", name)
+		}
+		result.library[name] = pattern
+	}
+
+	result.fields = make(map[string]*grokField)
+
+	expanded := make([]string, len(result.Patterns))
+	for i, pattern := range result.Patterns {
+		if expanded[i], err = result.expand(pattern, 0); err != nil {
+			return nil, fmt.Errorf("Failed to expand grok pattern, '%s': %s", pattern, err)
+		}
+	}
+
+	if err = result.patterns.Set(expanded, result.Match); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// expand replaces the pattern references in a pattern with the regular
+// expressions they refer to. References that name a field become capture
+// groups, with generated names that are mapped back to the field
+func (f *CodecGrokFactory) expand(pattern string, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", errors.New("Pattern references are nested too deeply, does a pattern refer to itself?")
+	}
+
+	var err error
+	result := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+
+		parts := grokReference.FindStringSubmatch(reference)
+
+		definition, ok := f.library[parts[1]]
+		if !ok {
+			err = fmt.Errorf("Unknown pattern %%{%s}", parts[1])
+			return ""
+		}
+
+		var expanded string
+		if expanded, err = f.expand(definition, depth+1); err != nil {
+			return ""
+		}
+
+		if parts[2] == "" {
+			return "(?:" + expanded + ")"
+		}
+
+		if parts[3] != "" && parts[3] != "int" && parts[3] != "float" {
+			err = fmt.Errorf("Unknown type '%s' for field '%s'", parts[3], parts[2])
+			return ""
+		}
+
+		group := fmt.Sprintf("_grok%d", len(f.fields))
+		f.fields[group] = &grokField{name: parts[2], conversion: parts[3]}
+		return "(?P<" + group + ">" + expanded + ")"
+	})
+
+	return result, err
+}
+
+// field returns the event field name and value for a capture
+func (f *CodecGrokFactory) field(group string, value string) (string, interface{}) {
+	field, ok := f.fields[group]
+	if !ok {
+		// A named group written directly in the pattern
+		return group, value
+	}
+
+	switch field.conversion {
+	case "int":
+		if converted, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return field.name, converted
+		}
+	case "float":
+		if converted, err := strconv.ParseFloat(value, 64); err == nil {
+			return field.name, converted
+		}
+	}
+
+	return field.name, value
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecGrokFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecGrok{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecGrok) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecGrok) Reset() {
+}
+
+// Flush is a no-op as the grok codec never buffers events
+func (c *CodecGrok) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// captures of the patterns are stored in the event fields, and a capture named
+// "message" replaces the line. Lines that do not match are shipped unchanged
+// with a "_grokparsefailure" tag
+func (c *CodecGrok) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	captures, matched := c.config.patterns.MatchCaptures(text)
+	if !matched {
+		c.missedLines++
+		fields.AddTag(grokParseFailureTag)
+		c.callbackFunc(startOffset, endOffset, text, fields)
+		return
+	}
+
+	c.matchedLines++
+
+	message := text
+	for group, value := range captures {
+		name, converted := c.config.field(group, value)
+		if name == "message" {
+			message = value
+			continue
+		}
+
+		setField(fields, name, converted, c.config.OverwriteKeys)
+	}
+
+	c.callbackFunc(startOffset, endOffset, message, fields)
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecGrok) Meter() {
+	c.meterMatched = c.matchedLines
+	c.meterMissed = c.missedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecGrok) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("matched_lines", admin.APINumber(c.meterMatched))
+	api.SetEntry("missed_lines", admin.APINumber(c.meterMissed))
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("grok", NewGrokCodecFactory)
+}
@@ -0,0 +1,241 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type grokEvent struct {
+	text   string
+	fields core.Event
+}
+
+var grokEvents []grokEvent
+
+func createGrokCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewGrokCodecFactory(config, "", unused, "grok")
+	if err != nil {
+		t.Errorf("Failed to create grok codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkGrok(startOffset int64, endOffset int64, text string, fields core.Event) {
+	grokEvents = append(grokEvents, grokEvent{text, fields})
+}
+
+func checkGrokFields(t *testing.T, fields core.Event, expected map[string]interface{}) {
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("Wrong %s field received: %v (expected %v)", key, fields[key], value)
+		}
+	}
+}
+
+func TestGrokSyslog(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{"^%{SYSLOGLINE}$"},
+	}, checkGrok, t)
+
+	codec.Event(0, 1, "Mar  7 10:15:01 web01 CRON[12345]: (root) CMD (run-parts /etc/cron.hourly)", nil)
+
+	if len(grokEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+
+	event := grokEvents[0]
+	if event.text != "(root) CMD (run-parts /etc/cron.hourly)" {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	checkGrokFields(t, event.fields, map[string]interface{}{
+		"timestamp": "Mar  7 10:15:01",
+		"logsource": "web01",
+		"program":   "CRON",
+		"pid":       "12345",
+	})
+
+	offset := codec.Teardown()
+	if offset != 1 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestGrokApache(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{"^%{COMBINEDAPACHELOG}$"},
+	}, checkGrok, t)
+
+	line := `192.168.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`
+	codec.Event(0, 1, line, nil)
+
+	if len(grokEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+
+	event := grokEvents[0]
+	if event.text != line {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	checkGrokFields(t, event.fields, map[string]interface{}{
+		"clientip":    "192.168.0.1",
+		"ident":       "-",
+		"auth":        "frank",
+		"timestamp":   "10/Oct/2000:13:55:36 -0700",
+		"verb":        "GET",
+		"request":     "/apache_pb.gif?a=1",
+		"httpversion": "1.0",
+		"response":    "200",
+		"bytes":       "2326",
+		"referrer":    `"http://www.example.com/start.html"`,
+		"agent":       `"Mozilla/4.08"`,
+	})
+	if _, ok := event.fields["rawrequest"]; ok {
+		t.Error("Unmatched capture was added to fields")
+	}
+}
+
+func TestGrokNginxError(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{"^%{NGINXERROR}$"},
+	}, checkGrok, t)
+
+	codec.Event(0, 1, `2017/02/18 12:01:02 [error] 1234#0: *56 open() "/var/www/missing" failed (2: No such file or directory)`, nil)
+
+	if len(grokEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+
+	event := grokEvents[0]
+	if event.text != `open() "/var/www/missing" failed (2: No such file or directory)` {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	checkGrokFields(t, event.fields, map[string]interface{}{
+		"timestamp":  "2017/02/18 12:01:02",
+		"level":      "error",
+		"pid":        "1234",
+		"tid":        "0",
+		"connection": "56",
+	})
+}
+
+func TestGrokIP(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{"^%{IP:address}$"},
+	}, checkGrok, t)
+
+	addresses := []string{"10.0.0.1", "2001:db8::1", "::1", "fe80::1:2:3:4", "2001:db8:0:0:0:0:2:1", "::ffff:192.0.2.128"}
+	for i, address := range addresses {
+		codec.Event(int64(i*2), int64(i*2+1), address, nil)
+	}
+	codec.Event(20, 21, "256.0.0.1", nil)
+
+	if len(grokEvents) != len(addresses)+1 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+
+	for i, address := range addresses {
+		if grokEvents[i].fields["address"] != address {
+			t.Errorf("Wrong address received: %v (expected %s)", grokEvents[i].fields["address"], address)
+		}
+	}
+
+	if !hasTag(grokEvents[len(addresses)].fields, "_grokparsefailure") {
+		t.Error("Invalid address was not tagged as a parse failure")
+	}
+}
+
+func TestGrokConversion(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{`^%{INT:status:int} %{NUMBER:duration:float} (?P<raw>\w+)$`},
+	}, checkGrok, t)
+
+	codec.Event(0, 1, "200 0.25 done", nil)
+
+	if len(grokEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+
+	checkGrokFields(t, grokEvents[0].fields, map[string]interface{}{
+		"status":   int64(200),
+		"duration": 0.25,
+		"raw":      "done",
+	})
+}
+
+func TestGrokCustomPatterns(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{"^%{REQUESTID:request_id} "},
+		"custom patterns": map[string]string{
+			"REQUESTID": `req-%{BASE16NUM}`,
+		},
+	}, checkGrok, t)
+
+	codec.Event(0, 1, "req-1f2e ok", nil)
+
+	if len(grokEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+
+	checkGrokFields(t, grokEvents[0].fields, map[string]interface{}{
+		"request_id": "req-1f2e",
+	})
+}
+
+func TestGrokOverwrite(t *testing.T) {
+	grokEvents = nil
+
+	codec := createGrokCodec(map[string]interface{}{
+		"patterns": []string{"^%{WORD:host}$"},
+	}, checkGrok, t)
+	codec.Event(0, 1, "decoded", core.Event{"host": "localhost"})
+
+	codec = createGrokCodec(map[string]interface{}{
+		"patterns":       []string{"^%{WORD:host}$"},
+		"overwrite keys": true,
+	}, checkGrok, t)
+	codec.Event(2, 3, "decoded", core.Event{"host": "localhost"})
+
+	if len(grokEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(grokEvents))
+	}
+	if grokEvents[0].fields["host"] != "localhost" {
+		t.Errorf("Existing field was overwritten: %v", grokEvents[0].fields["host"])
+	}
+	if grokEvents[1].fields["host"] != "decoded" {
+		t.Errorf("Existing field was not overwritten: %v", grokEvents[1].fields["host"])
+	}
+}
+
+func TestGrokInvalid(t *testing.T) {
+	config := config.NewConfig()
+
+	invalid := []map[string]interface{}{
+		{"patterns": []string{"%{UNKNOWN}"}},
+		{"patterns": []string{"%{LOOP}"}, "custom patterns": map[string]string{"LOOP": "%{LOOP}"}},
+		{"patterns": []string{"%{INT:value:bool}"}},
+	}
+
+	for _, unused := range invalid {
+		if _, err := NewGrokCodecFactory(config, "", unused, "grok"); err == nil {
+			t.Errorf("Invalid configuration was accepted: %v", unused)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultJSONMessageKey = "message"
+
+	// jsonParseFailureTag is added to events whose line could not be decoded
+	jsonParseFailureTag = "_jsonparsefailure"
+)
+
+// CodecJSONFactory holds the configuration for a JSON codec
+type CodecJSONFactory struct {
+	MessageKey    string `config:"message key"`
+	OverwriteKeys bool   `config:"overwrite keys"`
+	Target        string `config:"target"`
+}
+
+// CodecJSON is an instance of a JSON codec that is used by the Harvester to
+// decode lines into event fields
+type CodecJSON struct {
+	config       *CodecJSONFactory
+	lastOffset   int64
+	failedLines  uint64
+	callbackFunc CallbackFunc
+	meterFailed  uint64
+}
+
+// NewJSONCodecFactory creates a new JSONCodecFactory for a codec definition in
+// the configuration file. This factory can be used to create instances of a
+// JSON codec for use by harvesters
+func NewJSONCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	result := &CodecJSONFactory{}
+	if err := config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for the JSON codec
+func (f *CodecJSONFactory) InitDefaults() {
+	f.MessageKey = defaultJSONMessageKey
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecJSONFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecJSON{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecJSON) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecJSON) Reset() {
+}
+
+// Flush is a no-op as the JSON codec never buffers events
+func (c *CodecJSON) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// line is decoded and its keys are merged into the event fields. Lines that
+// fail to decode are shipped unchanged with a "_jsonparsefailure" tag
+func (c *CodecJSON) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	decoded, ok := c.decode(text)
+	if !ok {
+		c.failedLines++
+		fields.AddTag(jsonParseFailureTag)
+		c.callbackFunc(startOffset, endOffset, text, fields)
+		return
+	}
+
+	if c.config.Target != "" {
+		setField(fields, c.config.Target, decoded, c.config.OverwriteKeys)
+		c.callbackFunc(startOffset, endOffset, text, fields)
+		return
+	}
+
+	if c.config.MessageKey != "" {
+		if message, ok := decoded[c.config.MessageKey]; ok {
+			delete(decoded, c.config.MessageKey)
+			text = c.messageText(message)
+		}
+	}
+
+	for key, value := range decoded {
+		setField(fields, key, value, c.config.OverwriteKeys)
+	}
+
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// decode parses a line that must contain a single JSON object. Numbers are
+// kept in their original form so that large integers are not rounded
+func (c *CodecJSON) decode(text string) (map[string]interface{}, bool) {
+	var decoded map[string]interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil || decoded == nil {
+		return nil, false
+	}
+
+	// Reject anything following the object
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+
+	return decoded, true
+}
+
+// messageText returns the text to use as the message from the value at the
+// message key, encoding it as JSON if it is not a string
+func (c *CodecJSON) messageText(message interface{}) string {
+	if text, ok := message.(string); ok {
+		return text
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecJSON) Meter() {
+	c.meterFailed = c.failedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecJSON) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("failed_lines", admin.APINumber(c.meterFailed))
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("json", NewJSONCodecFactory)
+}
@@ -0,0 +1,182 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type jsonEvent struct {
+	text   string
+	fields core.Event
+}
+
+var jsonEvents []jsonEvent
+
+func createJSONCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewJSONCodecFactory(config, "", unused, "json")
+	if err != nil {
+		t.Errorf("Failed to create json codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkJSON(startOffset int64, endOffset int64, text string, fields core.Event) {
+	jsonEvents = append(jsonEvents, jsonEvent{text, fields})
+}
+
+func hasTag(fields core.Event, tag string) bool {
+	tags, ok := fields["tags"].([]string)
+	if !ok {
+		return false
+	}
+	for _, value := range tags {
+		if value == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJSON(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(map[string]interface{}{}, checkJSON, t)
+
+	codec.Event(0, 1, `{"message": "Decoded message", "level": "info", "count": 12345678901234567890}`, core.Event{"host": "localhost"})
+
+	if len(jsonEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	event := jsonEvents[0]
+	if event.text != "Decoded message" {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	if _, ok := event.fields["message"]; ok {
+		t.Error("Message key was not removed from fields")
+	}
+	if event.fields["level"] != "info" {
+		t.Errorf("Wrong level field received: %v", event.fields["level"])
+	}
+	if count, ok := event.fields["count"].(interface {
+		String() string
+	}); !ok || count.String() != "12345678901234567890" {
+		t.Errorf("Wrong count field received: %v", event.fields["count"])
+	}
+	if event.fields["host"] != "localhost" {
+		t.Errorf("Existing field was lost: %v", event.fields["host"])
+	}
+
+	offset := codec.Teardown()
+	if offset != 1 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestJSONOverwrite(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(map[string]interface{}{}, checkJSON, t)
+	codec.Event(0, 1, `{"host": "decoded"}`, core.Event{"host": "localhost"})
+
+	codec = createJSONCodec(map[string]interface{}{
+		"overwrite keys": true,
+	}, checkJSON, t)
+	codec.Event(2, 3, `{"host": "decoded"}`, core.Event{"host": "localhost"})
+
+	if len(jsonEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+	if jsonEvents[0].fields["host"] != "localhost" {
+		t.Errorf("Existing field was overwritten: %v", jsonEvents[0].fields["host"])
+	}
+	if jsonEvents[1].fields["host"] != "decoded" {
+		t.Errorf("Existing field was not overwritten: %v", jsonEvents[1].fields["host"])
+	}
+}
+
+func TestJSONTarget(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(map[string]interface{}{
+		"target": "json",
+	}, checkJSON, t)
+
+	line := `{"message": "Decoded message", "level": "info"}`
+	codec.Event(0, 1, line, nil)
+
+	if len(jsonEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	event := jsonEvents[0]
+	if event.text != line {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+
+	target, ok := event.fields["json"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Target field is missing or wrong type: %v", event.fields["json"])
+	}
+	if target["message"] != "Decoded message" || target["level"] != "info" {
+		t.Errorf("Wrong target field received: %v", target)
+	}
+}
+
+func TestJSONMessageKey(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(map[string]interface{}{
+		"message key": "msg",
+	}, checkJSON, t)
+
+	codec.Event(0, 1, `{"msg": {"nested": true}, "message": "other"}`, nil)
+
+	if len(jsonEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	event := jsonEvents[0]
+	if event.text != `{"nested":true}` {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	if event.fields["message"] != "other" {
+		t.Errorf("Wrong message field received: %v", event.fields["message"])
+	}
+}
+
+func TestJSONParseFailure(t *testing.T) {
+	jsonEvents = nil
+
+	codec := createJSONCodec(map[string]interface{}{}, checkJSON, t)
+
+	lines := []string{
+		`not json`,
+		`{"incomplete": `,
+		`["not", "an", "object"]`,
+		`null`,
+		`{"trailing": "data"} more`,
+	}
+	for i, line := range lines {
+		codec.Event(int64(i*2), int64(i*2+1), line, nil)
+	}
+
+	if len(jsonEvents) != len(lines) {
+		t.Fatalf("Wrong event count received: %d", len(jsonEvents))
+	}
+
+	for i, event := range jsonEvents {
+		if event.text != lines[i] {
+			t.Errorf("Wrong message received for line %d: %s", i, event.text)
+		}
+		if !hasTag(event.fields, "_jsonparsefailure") {
+			t.Errorf("Line %d was not tagged as a parse failure: %v", i, event.fields)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultKVFieldSeparator = " "
+	defaultKVMessageKey     = "message"
+	defaultKVQuotes         = "\""
+	defaultKVValueSeparator = "="
+
+	// kvParseFailureTag is added to events whose line could not be parsed
+	kvParseFailureTag = "_kvparsefailure"
+)
+
+var (
+	errKVUnterminatedQuote = errors.New("Unterminated quote")
+	errKVEmptyKey          = errors.New("Empty key")
+	errKVNoPairs           = errors.New("No key value pairs")
+)
+
+// CodecKVFactory holds the configuration for a key value codec
+type CodecKVFactory struct {
+	FieldSeparator string `config:"field separator"`
+	MessageKey     string `config:"message key"`
+	OverwriteKeys  bool   `config:"overwrite keys"`
+	Prefix         string `config:"prefix"`
+	Quotes         string `config:"quotes"`
+	ValueSeparator string `config:"value separator"`
+}
+
+// CodecKV is an instance of a key value codec that is used by the Harvester to
+// decode lines such as logfmt into event fields
+type CodecKV struct {
+	config       *CodecKVFactory
+	lastOffset   int64
+	failedLines  uint64
+	callbackFunc CallbackFunc
+	meterFailed  uint64
+}
+
+// NewKVCodecFactory creates a new KVCodecFactory for a codec definition in the
+// configuration file. This factory can be used to create instances of a key
+// value codec for use by harvesters
+func NewKVCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	result := &CodecKVFactory{}
+	if err := config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.FieldSeparator == "" {
+		return nil, errors.New("KV codec field separator can not be empty.")
+	}
+	if result.ValueSeparator == "" {
+		return nil, errors.New("KV codec value separator can not be empty.")
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for the key value codec
+func (f *CodecKVFactory) InitDefaults() {
+	f.FieldSeparator = defaultKVFieldSeparator
+	f.MessageKey = defaultKVMessageKey
+	f.Quotes = defaultKVQuotes
+	f.ValueSeparator = defaultKVValueSeparator
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecKVFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecKV{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecKV) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecKV) Reset() {
+}
+
+// Flush is a no-op as the key value codec never buffers events
+func (c *CodecKV) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// pairs in the line are stored in the event fields. Lines that can not be
+// parsed are shipped unchanged with a "_kvparsefailure" tag
+func (c *CodecKV) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	if fields == nil {
+		fields = core.Event{}
+	}
+
+	pairs, err := c.parse(text)
+	if err != nil {
+		c.failedLines++
+		fields.AddTag(kvParseFailureTag)
+		c.callbackFunc(startOffset, endOffset, text, fields)
+		return
+	}
+
+	message := text
+	for key, value := range pairs {
+		if c.config.MessageKey != "" && key == c.config.MessageKey {
+			if str, ok := value.(string); ok {
+				message = str
+				continue
+			}
+		}
+
+		setField(fields, c.config.Prefix+key, value, c.config.OverwriteKeys)
+	}
+
+	c.callbackFunc(startOffset, endOffset, message, fields)
+}
+
+// parse splits the line into its key value pairs. A key without a value
+// separator is given a value of true, as in logfmt, but at least one key must
+// have a value so that lines of plain text are not mistaken for a list of keys
+func (c *CodecKV) parse(text string) (map[string]interface{}, error) {
+	pairs := make(map[string]interface{})
+	valued := 0
+
+	for pos := 0; pos < len(text); {
+		if strings.HasPrefix(text[pos:], c.config.FieldSeparator) {
+			pos += len(c.config.FieldSeparator)
+			continue
+		}
+
+		key, next, err := c.token(text, pos, c.config.ValueSeparator, c.config.FieldSeparator)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, errKVEmptyKey
+		}
+		pos = next
+
+		if !strings.HasPrefix(text[pos:], c.config.ValueSeparator) {
+			pairs[key] = true
+			continue
+		}
+		pos += len(c.config.ValueSeparator)
+
+		value, next, err := c.token(text, pos, c.config.FieldSeparator, "")
+		if err != nil {
+			return nil, err
+		}
+		pos = next
+
+		pairs[key] = value
+		valued++
+	}
+
+	if valued == 0 {
+		return nil, errKVNoPairs
+	}
+
+	return pairs, nil
+}
+
+// token reads a key or value starting at the given position, stopping at
+// either of the given separators, and returns it along with the position
+// following it. Quoted sections may contain the separators, and within them a
+// backslash escapes the following character
+func (c *CodecKV) token(text string, pos int, stop string, altStop string) (string, int, error) {
+	var token []byte
+
+	for pos < len(text) {
+		if strings.HasPrefix(text[pos:], stop) || (altStop != "" && strings.HasPrefix(text[pos:], altStop)) {
+			break
+		}
+
+		quote := text[pos]
+		if c.config.Quotes == "" || strings.IndexByte(c.config.Quotes, quote) == -1 {
+			token = append(token, quote)
+			pos++
+			continue
+		}
+
+		// Quoted section
+		pos++
+		for {
+			if pos >= len(text) {
+				return "", 0, errKVUnterminatedQuote
+			}
+			if text[pos] == quote {
+				pos++
+				break
+			}
+			if text[pos] == '\\' && pos+1 < len(text) {
+				pos++
+			}
+			token = append(token, text[pos])
+			pos++
+		}
+	}
+
+	return string(token), pos, nil
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecKV) Meter() {
+	c.meterFailed = c.failedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecKV) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("failed_lines", admin.APINumber(c.meterFailed))
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("kv", NewKVCodecFactory)
+}
@@ -0,0 +1,144 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type kvEvent struct {
+	text   string
+	fields core.Event
+}
+
+var kvEvents []kvEvent
+
+func createKVCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewKVCodecFactory(config, "", unused, "kv")
+	if err != nil {
+		t.Errorf("Failed to create kv codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkKV(startOffset int64, endOffset int64, text string, fields core.Event) {
+	kvEvents = append(kvEvents, kvEvent{text, fields})
+}
+
+func checkKVFields(t *testing.T, fields core.Event, expected map[string]interface{}) {
+	for key, value := range expected {
+		if fields[key] != value {
+			t.Errorf("Wrong %s field received: %v (expected %v)", key, fields[key], value)
+		}
+	}
+}
+
+func TestKVLogfmt(t *testing.T) {
+	kvEvents = nil
+
+	codec := createKVCodec(map[string]interface{}{}, checkKV, t)
+
+	codec.Event(0, 1, `level=info  message="request \"done\"" path=/a?b=c dry_run duration=1.5ms empty=`, nil)
+
+	if len(kvEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(kvEvents))
+	}
+
+	event := kvEvents[0]
+	if event.text != `request "done"` {
+		t.Errorf("Wrong message received: %s", event.text)
+	}
+	checkKVFields(t, event.fields, map[string]interface{}{
+		"level":    "info",
+		"path":     "/a?b=c",
+		"dry_run":  true,
+		"duration": "1.5ms",
+		"empty":    "",
+	})
+	if _, ok := event.fields["message"]; ok {
+		t.Error("Message key was not removed from fields")
+	}
+
+	offset := codec.Teardown()
+	if offset != 1 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestKVSeparators(t *testing.T) {
+	kvEvents = nil
+
+	codec := createKVCodec(map[string]interface{}{
+		"field separator": ", ",
+		"value separator": ": ",
+		"quotes":          "'",
+		"prefix":          "kv_",
+	}, checkKV, t)
+
+	codec.Event(0, 1, `user: 'smith, john', id: 42`, nil)
+
+	if len(kvEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(kvEvents))
+	}
+
+	checkKVFields(t, kvEvents[0].fields, map[string]interface{}{
+		"kv_user": "smith, john",
+		"kv_id":   "42",
+	})
+}
+
+func TestKVOverwrite(t *testing.T) {
+	kvEvents = nil
+
+	codec := createKVCodec(map[string]interface{}{}, checkKV, t)
+	codec.Event(0, 1, "host=decoded", core.Event{"host": "localhost"})
+
+	codec = createKVCodec(map[string]interface{}{
+		"overwrite keys": true,
+	}, checkKV, t)
+	codec.Event(2, 3, "host=decoded", core.Event{"host": "localhost"})
+
+	if len(kvEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(kvEvents))
+	}
+	if kvEvents[0].fields["host"] != "localhost" {
+		t.Errorf("Existing field was overwritten: %v", kvEvents[0].fields["host"])
+	}
+	if kvEvents[1].fields["host"] != "decoded" {
+		t.Errorf("Existing field was not overwritten: %v", kvEvents[1].fields["host"])
+	}
+}
+
+func TestKVParseFailure(t *testing.T) {
+	kvEvents = nil
+
+	codec := createKVCodec(map[string]interface{}{}, checkKV, t)
+
+	lines := []string{
+		`just some plain text`,
+		`key="unterminated`,
+		`=value`,
+		``,
+	}
+	for i, line := range lines {
+		codec.Event(int64(i*2), int64(i*2+1), line, nil)
+	}
+
+	if len(kvEvents) != len(lines) {
+		t.Fatalf("Wrong event count received: %d", len(kvEvents))
+	}
+
+	for i, event := range kvEvents {
+		if event.text != lines[i] {
+			t.Errorf("Wrong message received for line %d: %s", i, event.text)
+		}
+		if !hasTag(event.fields, "_kvparsefailure") {
+			t.Errorf("Line %d was not tagged as a parse failure: %v", i, event.fields)
+		}
+	}
+}
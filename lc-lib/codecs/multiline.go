@@ -17,6 +17,7 @@
 package codecs
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -24,23 +25,32 @@ import (
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 const (
 	codecMultilineWhatPrevious = 0x00000001
 	codecMultilineWhatNext     = 0x00000002
+	codecMultilineWhatBlock    = 0x00000003
 )
 
 // CodecMultilineFactory holds the configuration for a multiline codec
 type CodecMultilineFactory struct {
 	Patterns          []string      `config:"patterns"`
+	Preset            string        `config:"preset"`
+	StartPatterns     []string      `config:"start patterns"`
+	EndPatterns       []string      `config:"end patterns"`
 	Match             string        `config:"match"`
 	What              string        `config:"what"`
 	PreviousTimeout   time.Duration `config:"previous timeout"`
 	MaxMultilineBytes int64         `config:"max multiline bytes"`
+	MaxLines          int64         `config:"max lines"`
+	DiscardPartial    bool          `config:"discard partial"`
 
-	patterns PatternCollection
-	what     int
+	patterns      PatternCollection
+	startPatterns PatternCollection
+	endPatterns   PatternCollection
+	what          int
 }
 
 // CodecMultiline is an instance of a multiline codec that is used by the
@@ -52,6 +62,9 @@ type CodecMultiline struct {
 
 	endOffset     int64
 	startOffset   int64
+	fields        core.Event
+	inBlock       bool
+	discarding    bool
 	buffer        []string
 	bufferLines   int64
 	bufferLen     int64
@@ -75,18 +88,55 @@ func NewMultilineCodecFactory(config *config.Config, configPath string, unused m
 		return nil, err
 	}
 
-	if err = result.patterns.Set(result.Patterns, result.Match); err != nil {
-		return nil, err
-	}
-
 	if result.What == "" || result.What == "previous" {
 		result.what = codecMultilineWhatPrevious
 	} else if result.What == "next" {
 		result.what = codecMultilineWhatNext
+	} else if result.What == "block" {
+		result.what = codecMultilineWhatBlock
 	} else {
 		return nil, fmt.Errorf("Unknown \"what\" value for multiline codec, '%s'.", result.What)
 	}
 
+	if result.Preset != "" && (result.what != codecMultilineWhatPrevious || result.Match == "all") {
+		return nil, errors.New("The \"preset\" option of the multiline codec requires \"what\" to be \"previous\" and \"match\" to be \"any\".")
+	}
+
+	if result.what == codecMultilineWhatBlock {
+		if err = result.startPatterns.Set(result.StartPatterns, result.Match); err != nil {
+			return nil, fmt.Errorf("Invalid \"start patterns\" for multiline codec: %s", err)
+		}
+
+		if err = result.endPatterns.Set(result.EndPatterns, result.Match); err != nil {
+			return nil, fmt.Errorf("Invalid \"end patterns\" for multiline codec: %s", err)
+		}
+	} else {
+		patterns := result.Patterns
+		if result.Preset != "" {
+			preset, ok := multilinePresets[result.Preset]
+			if !ok {
+				return nil, fmt.Errorf("Unknown \"preset\" value for multiline codec, '%s'.", result.Preset)
+			}
+
+			// Any patterns given are used in addition to those of the preset
+			patterns = make([]string, 0, len(result.Patterns)+len(preset))
+			patterns = append(patterns, result.Patterns...)
+			patterns = append(patterns, preset...)
+		}
+
+		if err = result.patterns.Set(patterns, result.Match); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.DiscardPartial && result.what == codecMultilineWhatNext {
+		return nil, errors.New("The \"discard partial\" option of the multiline codec can not be used when \"what\" is \"next\".")
+	}
+
+	if result.MaxLines < 0 {
+		return nil, errors.New("max lines for the multiline codec cannot be negative")
+	}
+
 	if result.MaxMultilineBytes == 0 {
 		result.MaxMultilineBytes = config.General.SpoolMaxBytes
 	}
@@ -136,39 +186,89 @@ func (c *CodecMultiline) Teardown() int64 {
 // stream
 func (c *CodecMultiline) Reset() {
 	c.lastOffset = 0
+	c.fields = nil
 	c.buffer = nil
 	c.bufferLen = 0
 	c.bufferLines = 0
+	c.inBlock = false
+	c.discarding = false
+}
+
+// StartPartial enables discarding of the lines at the start of the stream that
+// belong to an event that started before it, if discard partial is enabled
+func (c *CodecMultiline) StartPartial() {
+	c.discarding = c.config.DiscardPartial
+}
+
+// Flush sends any partially collected multiline event to the callback
+// immediately
+func (c *CodecMultiline) Flush() {
+	if c.config.PreviousTimeout != 0 {
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
+	c.flush()
 }
 
 // Event is called by a Harvester when a new line event occurs on a file.
 // Multiline processing takes place and when a complete multiline event is found
 // as described by the configuration it is shipped to the callback
-func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string) {
-	// TODO(driskell): If we are using previous and we match on the very first line read,
-	// then this is because we've started in the middle of a multiline event (the first line
-	// should never match) - so we could potentially offer an option to discard this.
-	// The benefit would be that when using previoustimeout, we could discard any extraneous
-	// event data that did not get written in time, if the user so wants it, in order to prevent
-	// odd incomplete data. It would be a signal from the user, "I will worry about the buffering
-	// issues my programs may have - you just make sure to write each event either completely or
-	// partially, always with the FIRST line correct (which could be the important one)."
-	matched := c.config.patterns.Match(text)
-
-	if c.config.what == codecMultilineWhatPrevious {
-		if c.config.PreviousTimeout != 0 {
-			// Prevent a flush happening while we're modifying the stored data
-			c.timerLock.Lock()
+func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	if c.config.PreviousTimeout != 0 && c.config.what != codecMultilineWhatNext {
+		// Prevent a flush happening while we're modifying the stored data
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
+	var flushAfter bool
+
+	switch c.config.what {
+	case codecMultilineWhatPrevious:
+		matched := c.config.patterns.Match(text)
+		if c.discarding {
+			if matched {
+				// The first line read continues an event that started before it,
+				// such as when starting from the end of a file, so discard it
+				c.discard(endOffset)
+				return
+			}
+			c.discarding = false
 		}
 		if !matched {
 			c.flush()
 		}
+	case codecMultilineWhatNext:
+		flushAfter = !c.config.patterns.Match(text)
+	case codecMultilineWhatBlock:
+		if c.config.startPatterns.Match(text) {
+			// Flush any block that never saw its end
+			c.flush()
+			c.inBlock = true
+			c.discarding = false
+		} else if c.discarding {
+			// Lines preceding the first start or end line may be the remainder
+			// of a block, which ends with the first end line
+			if c.config.endPatterns.Match(text) {
+				c.discarding = false
+			}
+			c.discard(endOffset)
+			return
+		}
+
+		// Lines outside of a block are events of their own
+		if !c.inBlock || c.config.endPatterns.Match(text) {
+			c.inBlock = false
+			flushAfter = true
+		}
 	}
 
 	textLen := int64(len(text))
 
+	// The combined event takes the fields of its first line
 	if len(c.buffer) == 0 {
 		c.startOffset = startOffset
+		c.fields = fields
 	}
 
 	// Check we don't exceed the max multiline bytes
@@ -184,10 +284,17 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 		c.bufferLines++
 		c.bufferLen += cut
 
+		// The remainder of the line starts a new event so needs its own copy of
+		// the fields, taken before they are passed on
+		if fields != nil {
+			fields = fields.Copy()
+		}
+
 		c.flush()
 
 		// Append the remaining data to the buffer
 		c.startOffset = c.endOffset
+		c.fields = fields
 		text = text[cut:]
 		textLen -= cut
 
@@ -201,15 +308,25 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 	c.bufferLines++
 	c.bufferLen += textLen
 
-	if c.config.what == codecMultilineWhatPrevious {
-		if c.config.PreviousTimeout != 0 {
-			// Reset the timer and unlock
-			c.timerDeadline = time.Now().Add(c.config.PreviousTimeout)
-			c.timerLock.Unlock()
-		}
-	} else if c.config.what == codecMultilineWhatNext && !matched {
+	if c.config.MaxLines != 0 && c.bufferLines >= c.config.MaxLines {
+		flushAfter = true
+	}
+
+	if flushAfter {
 		c.flush()
 	}
+
+	if c.config.PreviousTimeout != 0 && c.config.what != codecMultilineWhatNext {
+		// Reset the timer
+		c.timerDeadline = time.Now().Add(c.config.PreviousTimeout)
+	}
+}
+
+// discard drops a line that is part of an incomplete event at the start of the
+// stream, moving the offset on so that it is not read again
+func (c *CodecMultiline) discard(endOffset int64) {
+	c.endOffset = endOffset
+	c.lastOffset = endOffset
 }
 
 // flush is called internally when a multiline event is ready.
@@ -220,14 +337,16 @@ func (c *CodecMultiline) flush() {
 	}
 
 	text := strings.Join(c.buffer, "\n")
+	fields := c.fields
 
 	// Set last offset - this is returned in Teardown so if we're mid multiline and crash, we start this multiline again
 	c.lastOffset = c.endOffset
+	c.fields = nil
 	c.buffer = nil
 	c.bufferLen = 0
 	c.bufferLines = 0
 
-	c.callbackFunc(c.startOffset, c.endOffset, text)
+	c.callbackFunc(c.startOffset, c.endOffset, text, fields)
 }
 
 // Meter is called by the Harvester to request accounting
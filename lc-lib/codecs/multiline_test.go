@@ -1,15 +1,23 @@
 package codecs
 
 import (
+	"strings"
 	"sync"
 	"testing"
 	"time"
 	"unicode"
 
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 func createMultilineCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	return createMultilineCodecAt(unused, callback, 0, t)
+}
+
+// createMultilineCodecAt creates a multiline codec for a file read from the
+// given offset
+func createMultilineCodecAt(unused map[string]interface{}, callback CallbackFunc, offset int64, t *testing.T) Codec {
 	config := config.NewConfig()
 	config.General.MaxLineBytes = 1048576
 	config.General.SpoolMaxBytes = 10485760
@@ -20,7 +28,15 @@ func createMultilineCodec(unused map[string]interface{}, callback CallbackFunc,
 		t.FailNow()
 	}
 
-	return NewCodec(factory, callback, 0)
+	return NewCodec(factory, callback, offset)
+}
+
+// createPartialMultilineCodec creates a multiline codec for a file read from
+// the given offset, which may be part way through an event
+func createPartialMultilineCodec(unused map[string]interface{}, callback CallbackFunc, offset int64, t *testing.T) Codec {
+	codec := createMultilineCodecAt(unused, callback, offset, t)
+	StartPartial([]Codec{codec})
+	return codec
 }
 
 type checkMultilineExpect struct {
@@ -54,7 +70,7 @@ func (c *checkMultiline) incorrectLineCount(lines int, message string) {
 	c.t.Errorf("Expected: %d", len(c.expect))
 }
 
-func (c *checkMultiline) EventCallback(startOffset int64, endOffset int64, text string) {
+func (c *checkMultiline) EventCallback(startOffset int64, endOffset int64, text string, fields core.Event) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -117,10 +133,10 @@ func TestMultilinePrevious(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -147,10 +163,10 @@ func TestMultilinePreviousNegate(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -179,10 +195,10 @@ func TestMultilinePreviousTimeout(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	// Allow a second
 	time.Sleep(time.Second)
@@ -218,10 +234,10 @@ func TestMultilineNext(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -249,10 +265,10 @@ func TestMultilineNextNegate(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -281,10 +297,10 @@ func TestMultilineMaxBytes(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 16, "DEBUG First line")
-	codec.Event(17, 28, "second line")
-	codec.Event(29, 39, "third line")
-	codec.Event(40, 55, "DEBUG Next line")
+	codec.Event(0, 16, "DEBUG First line", nil)
+	codec.Event(17, 28, "second line", nil)
+	codec.Event(29, 39, "third line", nil)
+	codec.Event(40, 55, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -318,10 +334,10 @@ func TestMultilineMaxBytesOverflow(t *testing.T) {
 	// Also ensure we can split a single long line multiple times (issue #188)
 	// Lastly, ensure we flush immediately if we receive max multiline bytes
 	// rather than carrying over a full buffer and then crashing (issue #118)
-	codec.Event(0, 17, "START67890abcdefg")
-	codec.Event(18, 30, "1234567890ab")
-	codec.Event(31, 39, "c1234567")
-	codec.Event(40, 45, "START")
+	codec.Event(0, 17, "START67890abcdefg", nil)
+	codec.Event(18, 30, "1234567890ab", nil)
+	codec.Event(31, 39, "c1234567", nil)
+	codec.Event(40, 45, "START", nil)
 
 	check.CheckFinalCount()
 
@@ -349,12 +365,12 @@ func TestMultilineReset(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
 	codec.Reset()
-	codec.Event(4, 5, "DEBUG Next line")
-	codec.Event(6, 7, "ANOTHER line")
-	codec.Event(8, 9, "DEBUG Last line")
+	codec.Event(4, 5, "DEBUG Next line", nil)
+	codec.Event(6, 7, "ANOTHER line", nil)
+	codec.Event(8, 9, "DEBUG Last line", nil)
 
 	check.CheckFinalCount()
 
@@ -382,10 +398,10 @@ func TestMultilineMultiplePattern(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -413,10 +429,10 @@ func TestMultilineMultiplePatternAll(t *testing.T) {
 	)
 
 	// Send some data
-	codec.Event(0, 1, "DEBUG First line")
-	codec.Event(2, 3, "NEXT line")
-	codec.Event(4, 5, "ANOTHER line")
-	codec.Event(6, 7, "DEBUG Next line")
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+	codec.Event(6, 7, "DEBUG Next line", nil)
 
 	check.CheckFinalCount()
 
@@ -424,3 +440,483 @@ func TestMultilineMultiplePatternAll(t *testing.T) {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
+
+func TestMultilineFlush(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 3, "DEBUG First line\nNEXT line"},
+			{4, 5, "DEBUG Next line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"patterns": []string{"^(ANOTHER|NEXT) "},
+			"what":     "previous",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Flush()
+
+	check.CheckCurrentCount(1, "Flush did not send the buffered event")
+
+	codec.Event(4, 5, "DEBUG Next line", nil)
+	codec.Flush()
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilineBlock(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 1, "Outside line"},
+			{2, 7, "<event>\n  <id>1</id>\n</event>"},
+			{8, 9, "<event><id>2</id></event>"},
+			{10, 13, "<event>\n  <id>3</id>"},
+			{14, 17, "<event>\n</event>"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"start patterns": []string{"^<event>"},
+			"end patterns":   []string{"</event>$"},
+			"what":           "block",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codec.Event(0, 1, "Outside line", nil)
+	codec.Event(2, 3, "<event>", nil)
+	codec.Event(4, 5, "  <id>1</id>", nil)
+	codec.Event(6, 7, "</event>", nil)
+	codec.Event(8, 9, "<event><id>2</id></event>", nil)
+	// A block without an end is flushed by the start of the next
+	codec.Event(10, 11, "<event>", nil)
+	codec.Event(12, 13, "  <id>3</id>", nil)
+	codec.Event(14, 15, "<event>", nil)
+	codec.Event(16, 17, "</event>", nil)
+	codec.Event(18, 19, "<event>", nil)
+
+	check.CheckFinalCount()
+
+	if offset := codec.Teardown(); offset != 17 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilineBlockRequiresPatterns(t *testing.T) {
+	config := config.NewConfig()
+
+	_, err := NewMultilineCodecFactory(config, "", map[string]interface{}{
+		"patterns": []string{"^<event>"},
+		"what":     "block",
+	}, "multiline")
+	if err == nil {
+		t.Error("Block mode without start and end patterns did not fail")
+	}
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 5, "DEBUG First line\nNEXT line\nANOTHER line"},
+			{6, 7, "NEXT line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"max lines": int64(3),
+			"patterns":  []string{"^(ANOTHER|NEXT) "},
+			"what":      "previous",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codec.Event(0, 1, "DEBUG First line", nil)
+	codec.Event(2, 3, "NEXT line", nil)
+	codec.Event(4, 5, "ANOTHER line", nil)
+
+	check.CheckCurrentCount(1, "Max lines did not flush the buffered event")
+
+	codec.Event(6, 7, "NEXT line", nil)
+	codec.Event(8, 9, "DEBUG Next line", nil)
+
+	check.CheckFinalCount()
+
+	if offset := codec.Teardown(); offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilineDiscardPartial(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{14, 17, "DEBUG First line\nNEXT line"},
+			{18, 19, "NEXT line"},
+		},
+		t: t,
+	}
+
+	codec := createPartialMultilineCodec(
+		map[string]interface{}{
+			"discard partial": true,
+			"patterns":        []string{"^(ANOTHER|NEXT) "},
+			"what":            "previous",
+		},
+		check.EventCallback,
+		10,
+		t,
+	)
+
+	// Send some data
+	codec.Event(10, 11, "NEXT line", nil)
+	codec.Event(12, 13, "ANOTHER line", nil)
+
+	if offset := codec.Teardown(); offset != 13 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+
+	codec.Event(14, 15, "DEBUG First line", nil)
+	codec.Event(16, 17, "NEXT line", nil)
+	codec.Flush()
+
+	// Only the start of a stream is discarded
+	codec.Event(18, 19, "NEXT line", nil)
+	codec.Flush()
+
+	check.CheckFinalCount()
+}
+
+func TestMultilineDiscardPartialBlock(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{14, 15, "Outside line"},
+			{16, 19, "<event>\n</event>"},
+			{20, 21, "Outside line"},
+		},
+		t: t,
+	}
+
+	codec := createPartialMultilineCodec(
+		map[string]interface{}{
+			"discard partial": true,
+			"start patterns":  []string{"^<event>"},
+			"end patterns":    []string{"</event>$"},
+			"what":            "block",
+		},
+		check.EventCallback,
+		10,
+		t,
+	)
+
+	// Send some data
+	codec.Event(10, 11, "  <id>1</id>", nil)
+	codec.Event(12, 13, "</event>", nil)
+	codec.Event(14, 15, "Outside line", nil)
+	codec.Event(16, 17, "<event>", nil)
+	codec.Event(18, 19, "</event>", nil)
+	codec.Event(20, 21, "Outside line", nil)
+
+	check.CheckFinalCount()
+
+	if offset := codec.Teardown(); offset != 21 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestMultilineDiscardPartialStart(t *testing.T) {
+	// Nothing is discarded once a file is truncated, or when reading from the
+	// start of a file
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 3, "NEXT line\nANOTHER line"},
+			{4, 5, "NEXT line"},
+		},
+		t: t,
+	}
+
+	codec := createPartialMultilineCodec(
+		map[string]interface{}{
+			"discard partial": true,
+			"patterns":        []string{"^(ANOTHER|NEXT) "},
+			"what":            "previous",
+		},
+		check.EventCallback,
+		10,
+		t,
+	)
+
+	codec.Reset()
+	codec.Event(0, 1, "NEXT line", nil)
+	codec.Event(2, 3, "ANOTHER line", nil)
+	codec.Event(4, 5, "DEBUG line", nil)
+	codec.Reset()
+	codec.Event(4, 5, "NEXT line", nil)
+	codec.Flush()
+
+	check.CheckFinalCount()
+
+	check = &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 1, "Standalone line"},
+			{2, 3, "Another standalone line"},
+			{4, 7, "<event>\n</event>"},
+		},
+		t: t,
+	}
+
+	codec = createMultilineCodec(
+		map[string]interface{}{
+			"discard partial": true,
+			"start patterns":  []string{"^<event>"},
+			"end patterns":    []string{"</event>$"},
+			"what":            "block",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	codec.Event(0, 1, "Standalone line", nil)
+	codec.Event(2, 3, "Another standalone line", nil)
+	codec.Event(4, 5, "<event>", nil)
+	codec.Event(6, 7, "</event>", nil)
+
+	check.CheckFinalCount()
+}
+
+func TestMultilineDiscardPartialResume(t *testing.T) {
+	// Nothing is discarded when resuming from an offset saved after an event
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{10, 11, "Standalone line"},
+			{12, 13, "Another standalone line"},
+			{14, 17, "<event>\n</event>"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodecAt(
+		map[string]interface{}{
+			"discard partial": true,
+			"start patterns":  []string{"^<event>"},
+			"end patterns":    []string{"</event>$"},
+			"what":            "block",
+		},
+		check.EventCallback,
+		10,
+		t,
+	)
+
+	codec.Event(10, 11, "Standalone line", nil)
+	codec.Event(12, 13, "Another standalone line", nil)
+	codec.Event(14, 15, "<event>", nil)
+	codec.Event(16, 17, "</event>", nil)
+
+	check.CheckFinalCount()
+
+	if offset := codec.Teardown(); offset != 17 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+
+	check = &checkMultiline{
+		expect: []checkMultilineExpect{
+			{10, 13, "NEXT line\nANOTHER line"},
+		},
+		t: t,
+	}
+
+	codec = createMultilineCodecAt(
+		map[string]interface{}{
+			"discard partial": true,
+			"patterns":        []string{"^(ANOTHER|NEXT) "},
+			"what":            "previous",
+		},
+		check.EventCallback,
+		10,
+		t,
+	)
+
+	codec.Event(10, 11, "NEXT line", nil)
+	codec.Event(12, 13, "ANOTHER line", nil)
+	codec.Flush()
+
+	check.CheckFinalCount()
+}
+
+func TestMultilineDiscardPartialNext(t *testing.T) {
+	config := config.NewConfig()
+
+	_, err := NewMultilineCodecFactory(config, "", map[string]interface{}{
+		"discard partial": true,
+		"patterns":        []string{"^(ANOTHER|NEXT) "},
+		"what":            "next",
+	}, "multiline")
+	if err == nil {
+		t.Error("Discard partial with next did not fail")
+	}
+}
+
+// checkMultilineFixture sends each line of the fixture to a multiline codec
+// created with the given options, and checks that the expected events, each
+// of which must appear in the fixture, are received
+func checkMultilineFixture(t *testing.T, options map[string]interface{}, fixture string, expect []string) {
+	check := &checkMultiline{t: t}
+	for _, text := range expect {
+		start := int64(strings.Index(fixture, text))
+		if start == -1 {
+			t.Fatalf("Expected event not found in fixture: %s", text)
+		}
+		check.expect = append(check.expect, checkMultilineExpect{start, start + int64(len(text)), text})
+	}
+
+	codec := createMultilineCodec(options, check.EventCallback, t)
+
+	var offset int64
+	for _, line := range strings.Split(fixture, "\n") {
+		codec.Event(offset, offset+int64(len(line)), line, nil)
+		offset += int64(len(line)) + 1
+	}
+	codec.Flush()
+
+	check.CheckFinalCount()
+}
+
+func TestMultilinePresetJava(t *testing.T) {
+	trace := `2015-03-04 05:06:07 ERROR Request failed
+java.lang.IllegalStateException: Connection closed
+	at com.example.Client.send(Client.java:42)
+	at com.example.Handler.handle(Handler.java:17)
+Caused by: java.io.IOException: Broken pipe
+	at sun.nio.ch.FileDispatcherImpl.write0(Native Method)
+	... 12 more`
+	fixture := trace + `
+2015-03-04 05:06:08 INFO Recovered`
+
+	checkMultilineFixture(t, map[string]interface{}{"preset": "java"}, fixture, []string{
+		trace,
+		"2015-03-04 05:06:08 INFO Recovered",
+	})
+}
+
+func TestMultilinePresetPython(t *testing.T) {
+	trace := `2015-03-04 05:06:07,123 ERROR Request failed
+Traceback (most recent call last):
+  File "app.py", line 10, in handle
+    client.send(data)
+ConnectionError: Connection closed
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "app.py", line 12, in handle
+    raise RuntimeError("Request failed")
+RuntimeError: Request failed`
+	fixture := trace + `
+2015-03-04 05:06:08,000 INFO Recovered`
+
+	checkMultilineFixture(t, map[string]interface{}{"preset": "python"}, fixture, []string{
+		trace,
+		"2015-03-04 05:06:08,000 INFO Recovered",
+	})
+}
+
+func TestMultilinePresetGoPanic(t *testing.T) {
+	panic := `panic: runtime error: index out of range [signal SIGSEGV]
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x401000]
+
+goroutine 1 [running]:
+main.process(0xc42000e1e0, 0x3, 0x3)
+	/go/src/example/main.go:8 +0x1d
+main.main()
+	/go/src/example/main.go:14 +0x5a
+
+goroutine 5 [chan receive]:
+main.(*worker).run(0xc42000e200)
+	/go/src/example/worker.go:20 +0x40
+created by main.main
+	/go/src/example/main.go:12 +0x3c`
+	fixture := `2015/03/04 05:06:07 Starting worker
+` + panic + `
+2015/03/04 05:06:08 Starting worker`
+
+	checkMultilineFixture(t, map[string]interface{}{"preset": "go_panic"}, fixture, []string{
+		"2015/03/04 05:06:07 Starting worker",
+		panic,
+		"2015/03/04 05:06:08 Starting worker",
+	})
+}
+
+func TestMultilinePresetDotnet(t *testing.T) {
+	trace := `2015-03-04 05:06:07 ERROR Request failed
+System.InvalidOperationException: Request failed ---> System.IO.IOException: Broken pipe
+   at Example.Client.Send() in C:\src\Client.cs:line 42
+   --- End of inner exception stack trace ---
+   at Example.Handler.Handle() in C:\src\Handler.cs:line 17
+--- End of stack trace from previous location where exception was thrown ---
+   at Example.Program.Main()`
+	fixture := trace + `
+Unhandled exception. System.ArgumentNullException: Value cannot be null.
+   at Example.Program.Main()
+2015-03-04 05:06:08 INFO Recovered`
+
+	checkMultilineFixture(t, map[string]interface{}{"preset": "dotnet"}, fixture, []string{
+		trace,
+		"Unhandled exception. System.ArgumentNullException: Value cannot be null.\n   at Example.Program.Main()",
+		"2015-03-04 05:06:08 INFO Recovered",
+	})
+}
+
+func TestMultilinePresetExtraPatterns(t *testing.T) {
+	trace := `2015-03-04 05:06:07 ERROR Request failed
+Request ID: 1234
+java.lang.IllegalStateException: Connection closed
+	at com.example.Client.send(Client.java:42)`
+	fixture := trace + `
+2015-03-04 05:06:08 INFO Recovered`
+
+	checkMultilineFixture(t, map[string]interface{}{
+		"patterns": []string{"^Request ID: "},
+		"preset":   "java",
+	}, fixture, []string{
+		trace,
+		"2015-03-04 05:06:08 INFO Recovered",
+	})
+}
+
+func TestMultilinePresetInvalid(t *testing.T) {
+	config := config.NewConfig()
+
+	_, err := NewMultilineCodecFactory(config, "", map[string]interface{}{
+		"preset": "cobol",
+	}, "multiline")
+	if err == nil {
+		t.Error("Unknown preset did not fail")
+	}
+
+	_, err = NewMultilineCodecFactory(config, "", map[string]interface{}{
+		"preset": "java",
+		"what":   "next",
+	}, "multiline")
+	if err == nil {
+		t.Error("Preset with next did not fail")
+	}
+}
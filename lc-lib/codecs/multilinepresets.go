@@ -0,0 +1,63 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+// multilinePresets are the built-in pattern sets available to the multiline
+// codec through its "preset" option. Each matches the continuation lines of a
+// stack trace, so that the lines are combined with the log line preceding them
+// when "what" is "previous"
+var multilinePresets = map[string][]string{
+	// Stack frames, "Caused by" and "Suppressed" sections, elided frames, and
+	// the exception line following the log message
+	"java": {
+		`^\s+at `,
+		`^\s+\.\.\. [0-9]+ (more|common frames omitted)`,
+		`^(\s+Suppressed: |Caused by: )`,
+		`^([a-zA-Z_$][a-zA-Z0-9_$]*\.)+[a-zA-Z_$][a-zA-Z0-9_$]*(Exception|Error|Throwable)(: |$)`,
+	},
+
+	// Traceback header, indented file and source lines, chained exception
+	// separators and the blank lines around them, and the final exception line
+	"python": {
+		`^Traceback \(most recent call last\):`,
+		`^\s+`,
+		`^$`,
+		`^(During handling of the above exception|The above exception was the direct cause)`,
+		`^([a-zA-Z_][a-zA-Z0-9_]*\.)*[a-zA-Z_][a-zA-Z0-9_]*(Error|Exception|Exit|Interrupt|Warning)(: |$)`,
+	},
+
+	// Everything following the "panic:" or "fatal error:" line up to the end
+	// of the goroutine dumps: blank lines, goroutine headers, function calls
+	// and their tab indented source locations
+	"go_panic": {
+		`^$`,
+		`^goroutine [0-9]+ \[`,
+		`^\t`,
+		`^created by `,
+		`^[^\s(]+\(.*\)$`,
+		`^\[signal `,
+	},
+
+	// Stack frames, inner exceptions and their separators, and the exception
+	// line following the log message
+	"dotnet": {
+		`^\s+at `,
+		`^\s*--- End of `,
+		`^\s*---> `,
+		`^([a-zA-Z_][a-zA-Z0-9_]*\.)+[a-zA-Z_][a-zA-Z0-9_]*Exception(: |$)`,
+	},
+}
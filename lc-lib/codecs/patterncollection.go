@@ -93,3 +93,45 @@ func (c *PatternCollection) Match(text string) bool {
 
 	return false
 }
+
+// MatchCaptures attempts to match the given text against the set patterns in
+// the same way as Match, and also returns the values of the named capture
+// groups of the patterns that matched. Groups that did not take part in the
+// match are not returned, and where more than one group has the same name the
+// first value found is used
+func (c *PatternCollection) MatchCaptures(text string) (map[string]string, bool) {
+	if c.patterns == nil {
+		panic("Patterns not set")
+	}
+
+	var matchCount int
+	captures := make(map[string]string)
+	for _, pattern := range c.patterns {
+		if pattern.negate {
+			if pattern.matcher.MatchString(text) {
+				continue
+			}
+		} else {
+			indices := pattern.matcher.FindStringSubmatchIndex(text)
+			if indices == nil {
+				continue
+			}
+
+			for i, name := range pattern.matcher.SubexpNames() {
+				if name == "" || indices[i*2] < 0 {
+					continue
+				}
+				if _, ok := captures[name]; !ok {
+					captures[name] = text[indices[i*2]:indices[i*2+1]]
+				}
+			}
+		}
+
+		matchCount++
+		if matchCount == c.requiredMatches {
+			return captures, true
+		}
+	}
+
+	return nil, false
+}
@@ -19,6 +19,7 @@ package codecs
 import (
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
 )
 
 // CodecPlainFactory holds the configuration, it is responsible for generating
@@ -66,12 +67,18 @@ func (c *CodecPlain) Teardown() int64 {
 func (c *CodecPlain) Reset() {
 }
 
+// Flush is called when a log file is truncated and the truncation policy is to
+// flush, and it should cause the codec to pass any buffered events to the
+// callback immediately, instead of losing them in the Reset that follows
+func (c *CodecPlain) Flush() {
+}
+
 // Event is called for every log event, the resulting log event(s) to be
 // transmitted should be passed through the codec callback when ready
-func (c *CodecPlain) Event(startOffset int64, endOffset int64, text string) {
+func (c *CodecPlain) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
 	c.lastOffset = endOffset
 
-	c.callbackFunc(startOffset, endOffset, text)
+	c.callbackFunc(startOffset, endOffset, text, fields)
 }
 
 // Meter is called by the harvester periodically to allow the codec to calculate
@@ -0,0 +1,368 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultRedactReplacement = "[REDACTED]"
+
+	// redactValueGroup is the name of the capture group that, when present in
+	// a pattern, contains the value to replace instead of the whole match
+	redactValueGroup = "value"
+
+	// redactHashLength is the number of hex characters of the HMAC to keep
+	redactHashLength = 32
+)
+
+// redactDetector is a built-in pattern, along with a check of the values it
+// finds where a regular expression alone would find too much, or a function
+// that finds the values to redact within each match
+type redactDetector struct {
+	pattern string
+	valid   func(string) bool
+	find    func(string) [][]int
+}
+
+// redactDetectors are the built-in patterns available to redact codec rules
+// through their "detector" option
+var redactDetectors = map[string]redactDetector{
+	"credit_card":  {`\b[0-9](?:[ -]?[0-9])*\b`, nil, cardNumbers},
+	"email":        {`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`, nil, nil},
+	"ipv4":         {`\b[0-9]{1,3}(?:\.[0-9]{1,3}){3,}\b`, ipv4Valid, nil},
+	"bearer_token": {`(?i)\bbearer\s+(?P<value>[A-Za-z0-9._~+/-]+=*)`, nil, nil},
+}
+
+// CodecRedactRule holds the configuration for a single redact codec rule
+type CodecRedactRule struct {
+	Detector    string `config:"detector"`
+	Hash        bool   `config:"hash"`
+	Name        string `config:"name"`
+	Pattern     string `config:"pattern"`
+	Replacement string `config:"replacement"`
+
+	matcher    *regexp.Regexp
+	valueGroup int
+	valid      func(string) bool
+	find       func(string) [][]int
+}
+
+// InitDefaults initialises the default configuration for a redact codec rule
+func (r *CodecRedactRule) InitDefaults() {
+	r.Replacement = defaultRedactReplacement
+}
+
+// CodecRedactFactory holds the configuration for a redact codec
+type CodecRedactFactory struct {
+	Fields  []string          `config:"fields"`
+	HashKey string            `config:"hash key"`
+	Rules   []CodecRedactRule `config:"rules"`
+}
+
+// CodecRedact is an instance of a redact codec that is used by the Harvester
+// to mask sensitive values in events before they are shipped
+type CodecRedact struct {
+	config       *CodecRedactFactory
+	lastOffset   int64
+	redactions   []uint64
+	callbackFunc CallbackFunc
+	meterCounts  []uint64
+}
+
+// NewRedactCodecFactory creates a new RedactCodecFactory for a codec definition
+// in the configuration file. This factory can be used to create instances of a
+// redact codec for use by harvesters
+func NewRedactCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecRedactFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Rules) == 0 {
+		return nil, errors.New("Redact codec rules must be specified.")
+	}
+
+	for k := range result.Rules {
+		if err = result.initRule(k, &result.Rules[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// initRule validates a rule and compiles its pattern
+func (f *CodecRedactFactory) initRule(k int, rule *CodecRedactRule) (err error) {
+	if (rule.Detector == "") == (rule.Pattern == "") {
+		return fmt.Errorf("Redact codec rule %d requires one of detector or pattern to be specified.", k)
+	}
+
+	pattern := rule.Pattern
+	if rule.Detector != "" {
+		detector, ok := redactDetectors[rule.Detector]
+		if !ok {
+			return fmt.Errorf("Unknown \"detector\" value for redact codec rule %d, '%s'.", k, rule.Detector)
+		}
+
+		pattern = detector.pattern
+		rule.valid = detector.valid
+		rule.find = detector.find
+	}
+
+	if rule.Name == "" {
+		if rule.Detector != "" {
+			rule.Name = rule.Detector
+		} else {
+			rule.Name = fmt.Sprintf("rule_%d", k)
+		}
+	}
+
+	if rule.Hash && f.HashKey == "" {
+		return fmt.Errorf("Redact codec rule %d uses hash but no hash key was specified.", k)
+	}
+
+	if rule.matcher, err = regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("Failed to compile redact codec pattern, '%s': %s", pattern, err)
+	}
+
+	for i, name := range rule.matcher.SubexpNames() {
+		if name == redactValueGroup {
+			rule.valueGroup = i
+			break
+		}
+	}
+
+	return nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecRedactFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecRedact{
+		config:       f,
+		lastOffset:   offset,
+		redactions:   make([]uint64, len(f.Rules)),
+		callbackFunc: callbackFunc,
+		meterCounts:  make([]uint64, len(f.Rules)),
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecRedact) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecRedact) Reset() {
+}
+
+// Flush is a no-op as the redact codec never buffers events
+func (c *CodecRedact) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. Each
+// rule is applied in turn to the line and to the configured fields, replacing
+// the values it finds
+func (c *CodecRedact) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	text = c.redact(text)
+
+	for _, key := range c.config.Fields {
+		if value, ok := fields[key].(string); ok {
+			fields[key] = c.redact(value)
+		}
+	}
+
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// redact applies all rules to the given value
+func (c *CodecRedact) redact(value string) string {
+	for k := range c.config.Rules {
+		value = c.redactRule(k, &c.config.Rules[k], value)
+	}
+	return value
+}
+
+// redactRule replaces each value found by a rule. The value is the "value"
+// capture group of the pattern if it has one, or the whole match otherwise
+func (c *CodecRedact) redactRule(k int, rule *CodecRedactRule, value string) string {
+	matches := rule.matcher.FindAllStringSubmatchIndex(value, -1)
+	if matches == nil {
+		return value
+	}
+
+	var result bytes.Buffer
+	var last int
+	for _, match := range matches {
+		start, end := match[rule.valueGroup*2], match[rule.valueGroup*2+1]
+		if start < 0 {
+			continue
+		}
+
+		found := value[start:end]
+		if rule.valid != nil && !rule.valid(found) {
+			continue
+		}
+
+		ranges := [][]int{{0, len(found)}}
+		if rule.find != nil {
+			ranges = rule.find(found)
+		}
+
+		for _, r := range ranges {
+			result.WriteString(value[last : start+r[0]])
+			if rule.Hash {
+				result.WriteString(c.hash(found[r[0]:r[1]]))
+			} else {
+				result.WriteString(rule.Replacement)
+			}
+			last = start + r[1]
+
+			c.redactions[k]++
+		}
+	}
+
+	if last == 0 {
+		return value
+	}
+
+	result.WriteString(value[last:])
+	return result.String()
+}
+
+// hash returns the keyed HMAC of a value, so that the same value always has
+// the same replacement without the value being recoverable
+func (c *CodecRedact) hash(value string) string {
+	mac := hmac.New(sha256.New, []byte(c.config.HashKey))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))[:redactHashLength]
+}
+
+// cardNumbers returns the ranges of payment card numbers within a run of digit
+// groups. The run can include other numbers next to a card number, such as a
+// time or an ID before it, so each sequence of whole groups is checked, longest
+// first, and the groups following a card number are checked for another
+func cardNumbers(value string) [][]int {
+	var groups [][]int
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i == len(value) || value[i] == ' ' || value[i] == '-' {
+			groups = append(groups, []int{start, i})
+			start = i + 1
+		}
+	}
+
+	var found [][]int
+	for i := 0; i < len(groups); i++ {
+		for j := len(groups) - 1; j >= i; j-- {
+			if luhnValid(value[groups[i][0]:groups[j][1]]) {
+				found = append(found, []int{groups[i][0], groups[j][1]})
+				i = j
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+// luhnValid returns true if the digits of the value pass the Luhn checksum
+// used by payment card numbers. Spaces and hyphens are ignored
+func luhnValid(value string) bool {
+	var sum, digits int
+	double := false
+	for i := len(value) - 1; i >= 0; i-- {
+		char := value[i]
+		if char == ' ' || char == '-' {
+			continue
+		}
+		if char < '0' || char > '9' {
+			return false
+		}
+
+		digit := int(char - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+		double = !double
+	}
+
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// ipv4Valid returns true if the value is a dotted quad IPv4 address, and not,
+// for example, part of a longer version number
+func ipv4Valid(value string) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 {
+		return false
+	}
+
+	for _, part := range parts {
+		if number, err := strconv.Atoi(part); err != nil || number > 255 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecRedact) Meter() {
+	copy(c.meterCounts, c.redactions)
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecRedact) APIEncodable() admin.APIEncodable {
+	rules := &admin.APIKeyValue{}
+	for k := range c.config.Rules {
+		rules.SetEntry(c.config.Rules[k].Name, admin.APINumber(c.meterCounts[k]))
+	}
+
+	api := &admin.APIKeyValue{}
+	api.SetEntry("redactions", rules)
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("redact", NewRedactCodecFactory)
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultSampleRateField = "sample_rate"
+
+	// samplePercentageScale is the denominator of the fraction of events kept
+	// when sampling by percentage, allowing up to four decimal places
+	samplePercentageScale = 1000000
+)
+
+// CodecSampleFactory holds the configuration for a sample codec
+type CodecSampleFactory struct {
+	KeyPattern string  `config:"key pattern"`
+	Percentage float64 `config:"percentage"`
+	Rate       int64   `config:"rate"`
+	RateField  string  `config:"rate field"`
+
+	keyPattern *regexp.Regexp
+	// The fraction of events kept is keep / of
+	keep uint64
+	of   uint64
+	// rate is the number of events each kept event represents
+	rate float64
+}
+
+// CodecSample is an instance of a sample codec that is used by the Harvester
+// to ship a fraction of events
+type CodecSample struct {
+	config       *CodecSampleFactory
+	lastOffset   int64
+	accumulator  uint64
+	keptLines    uint64
+	droppedLines uint64
+	callbackFunc CallbackFunc
+	meterKept    uint64
+	meterDropped uint64
+}
+
+// NewSampleCodecFactory creates a new SampleCodecFactory for a codec definition
+// in the configuration file. This factory can be used to create instances of a
+// sample codec for use by harvesters
+func NewSampleCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecSampleFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if (result.Rate == 0) == (result.Percentage == 0) {
+		return nil, errors.New("Sample codec requires one of rate or percentage to be specified.")
+	}
+
+	if result.Rate != 0 {
+		if result.Rate < 1 {
+			return nil, errors.New("Sample codec rate must be at least 1.")
+		}
+		result.keep = 1
+		result.of = uint64(result.Rate)
+		result.rate = float64(result.Rate)
+	} else {
+		if result.Percentage < 0 || result.Percentage > 100 {
+			return nil, errors.New("Sample codec percentage must be greater than 0 and no more than 100.")
+		}
+		result.keep = uint64(math.Floor(result.Percentage*samplePercentageScale/100 + 0.5))
+		if result.keep == 0 {
+			return nil, errors.New("Sample codec percentage is too small.")
+		}
+		result.of = samplePercentageScale
+		result.rate = 100 / result.Percentage
+	}
+
+	if result.KeyPattern != "" {
+		if result.keyPattern, err = regexp.Compile(result.KeyPattern); err != nil {
+			return nil, fmt.Errorf("Failed to compile sample codec key pattern, '%s': %s", result.KeyPattern, err)
+		}
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for the sample codec
+func (f *CodecSampleFactory) InitDefaults() {
+	f.RateField = defaultSampleRateField
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecSampleFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return &CodecSample{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecSample) Teardown() int64 {
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecSample) Reset() {
+	c.accumulator = 0
+}
+
+// Flush is a no-op as the sample codec never buffers events
+func (c *CodecSample) Flush() {
+}
+
+// Event is called by a Harvester when a new line event occurs on a file.
+// Sampling takes place and only the kept lines are shipped to the callback
+func (c *CodecSample) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+
+	if !c.sample(text) {
+		c.droppedLines++
+		return
+	}
+
+	c.keptLines++
+
+	if c.config.RateField != "" {
+		if fields == nil {
+			fields = core.Event{}
+		}
+		fields[c.config.RateField] = c.config.rate
+	}
+
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// sample decides whether to keep a line. Lines with a key are kept or dropped
+// according to a hash of the key, so that all lines with the same key receive
+// the same decision. Other lines are kept at an exact rate
+func (c *CodecSample) sample(text string) bool {
+	if key, ok := c.key(text); ok {
+		hash := fnv.New32a()
+		hash.Write([]byte(key))
+		return uint64(hash.Sum32())%c.config.of < c.config.keep
+	}
+
+	c.accumulator += c.config.keep
+	if c.accumulator >= c.config.of {
+		c.accumulator -= c.config.of
+		return true
+	}
+
+	return false
+}
+
+// key returns the key for a line from the key pattern. This is the first
+// capture group that took part in the match, or the whole match if there are
+// no capture groups
+func (c *CodecSample) key(text string) (string, bool) {
+	if c.config.keyPattern == nil {
+		return "", false
+	}
+
+	match := c.config.keyPattern.FindStringSubmatch(text)
+	if match == nil {
+		return "", false
+	}
+
+	if len(match) == 1 {
+		return match[0], true
+	}
+
+	for _, value := range match[1:] {
+		if value != "" {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecSample) Meter() {
+	c.meterKept = c.keptLines
+	c.meterDropped = c.droppedLines
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecSample) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("kept_lines", admin.APINumber(c.meterKept))
+	api.SetEntry("dropped_lines", admin.APINumber(c.meterDropped))
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("sample", NewSampleCodecFactory)
+}
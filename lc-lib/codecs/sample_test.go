@@ -0,0 +1,156 @@
+package codecs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var sampleEvents []jsonEvent
+
+func createSampleCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+
+	factory, err := NewSampleCodecFactory(config, "", unused, "sample")
+	if err != nil {
+		t.Errorf("Failed to create sample codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkSample(startOffset int64, endOffset int64, text string, fields core.Event) {
+	sampleEvents = append(sampleEvents, jsonEvent{text, fields})
+}
+
+func TestSampleRate(t *testing.T) {
+	sampleEvents = nil
+
+	codec := createSampleCodec(map[string]interface{}{
+		"rate": int64(3),
+	}, checkSample, t)
+
+	for i := 0; i < 10; i++ {
+		codec.Event(int64(i*2), int64(i*2+1), fmt.Sprintf("Line %d", i+1), nil)
+	}
+
+	if len(sampleEvents) != 3 {
+		t.Fatalf("Wrong event count received: %d", len(sampleEvents))
+	}
+
+	for i, text := range []string{"Line 3", "Line 6", "Line 9"} {
+		if sampleEvents[i].text != text {
+			t.Errorf("Wrong event %d received: %s", i, sampleEvents[i].text)
+		}
+		if sampleEvents[i].fields["sample_rate"] != float64(3) {
+			t.Errorf("Wrong sample rate for event %d: %v", i, sampleEvents[i].fields["sample_rate"])
+		}
+	}
+
+	// Dropped lines must still move the offset on
+	if offset := codec.Teardown(); offset != 19 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestSamplePercentage(t *testing.T) {
+	sampleEvents = nil
+
+	codec := createSampleCodec(map[string]interface{}{
+		"percentage": 12.5,
+		"rate field": "sampled",
+	}, checkSample, t)
+
+	for i := 0; i < 80; i++ {
+		codec.Event(0, 1, "Line", core.Event{"host": "localhost"})
+	}
+
+	if len(sampleEvents) != 10 {
+		t.Fatalf("Wrong event count received: %d", len(sampleEvents))
+	}
+
+	if sampleEvents[0].fields["sampled"] != float64(8) {
+		t.Errorf("Wrong sample rate received: %v", sampleEvents[0].fields["sampled"])
+	}
+	if _, ok := sampleEvents[0].fields["sample_rate"]; ok {
+		t.Errorf("Default sample rate field was added: %v", sampleEvents[0].fields)
+	}
+}
+
+func TestSampleKey(t *testing.T) {
+	sampleEvents = nil
+
+	codec := createSampleCodec(map[string]interface{}{
+		"key pattern": `request_id=([0-9a-f]+)`,
+		"percentage":  float64(50),
+		"rate field":  "",
+	}, checkSample, t)
+
+	kept := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("%08x", i*7919)
+		for _, stage := range []string{"start", "query", "end"} {
+			codec.Event(0, 1, fmt.Sprintf("request_id=%s stage=%s", id, stage), nil)
+		}
+		kept[id] = 0
+	}
+
+	for _, event := range sampleEvents {
+		var id, stage string
+		fmt.Sscanf(event.text, "request_id=%s stage=%s", &id, &stage)
+		kept[id]++
+		if event.fields != nil {
+			t.Errorf("Rate field was added when disabled: %v", event.fields)
+		}
+	}
+
+	var keptRequests int
+	for id, count := range kept {
+		if count != 0 && count != 3 {
+			t.Errorf("Request %s was partially kept: %d of 3 lines", id, count)
+		}
+		if count == 3 {
+			keptRequests++
+		}
+	}
+
+	if keptRequests < 30 || keptRequests > 70 {
+		t.Errorf("Unexpected number of requests kept: %d", keptRequests)
+	}
+}
+
+func TestSampleIntegerPercentage(t *testing.T) {
+	sampleEvents = nil
+
+	// YAML configuration files decode whole numbers as integers
+	codec := createSampleCodec(map[string]interface{}{
+		"percentage": 50,
+	}, checkSample, t)
+
+	for i := 0; i < 4; i++ {
+		codec.Event(0, 1, "Line", nil)
+	}
+
+	if len(sampleEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(sampleEvents))
+	}
+}
+
+func TestSampleInvalidConfig(t *testing.T) {
+	config := config.NewConfig()
+
+	for _, unused := range []map[string]interface{}{
+		{},
+		{"rate": int64(10), "percentage": float64(10)},
+		{"rate": int64(-1)},
+		{"percentage": float64(101)},
+		{"rate": int64(10), "key pattern": "("},
+	} {
+		if _, err := NewSampleCodecFactory(config, "", unused, "sample"); err == nil {
+			t.Errorf("Invalid configuration did not fail: %v", unused)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// switchDefaultName is the name of the branch for lines that match no case
+const switchDefaultName = "default"
+
+// CodecSwitchCase holds the configuration for a single case of a switch codec
+type CodecSwitchCase struct {
+	Codecs   []config.CodecStub `config:"codecs"`
+	Match    string             `config:"match"`
+	Name     string             `config:"name"`
+	Patterns []string           `config:"patterns"`
+
+	patterns PatternCollection
+}
+
+// CodecSwitchFactory holds the configuration for a switch codec
+type CodecSwitchFactory struct {
+	Cases   []CodecSwitchCase  `config:"cases"`
+	Default []config.CodecStub `config:"default"`
+}
+
+// switchBranch is an instance of the codecs of a case, or of the default codecs
+type switchBranch struct {
+	name         string
+	chain        []Codec
+	matchedLines uint64
+	meterMatched uint64
+}
+
+// CodecSwitch is an instance of a switch codec that is used by the Harvester to
+// send each line through different codecs depending on its content
+type CodecSwitch struct {
+	config       *CodecSwitchFactory
+	lastOffset   int64
+	callbackFunc CallbackFunc
+
+	// branches has one entry per case, followed by the default branch
+	branches []*switchBranch
+	current  *switchBranch
+}
+
+// NewSwitchCodecFactory creates a new SwitchCodecFactory for a codec definition
+// in the configuration file. This factory can be used to create instances of a
+// switch codec for use by harvesters
+func NewSwitchCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecSwitchFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Cases) == 0 {
+		return nil, errors.New("Switch codec cases must be specified.")
+	}
+
+	names := map[string]bool{switchDefaultName: true}
+	for k := range result.Cases {
+		switchCase := &result.Cases[k]
+
+		if switchCase.Name == "" {
+			switchCase.Name = fmt.Sprintf("case_%d", k)
+		}
+		if names[switchCase.Name] {
+			return nil, fmt.Errorf("Switch codec case name is not unique, '%s'.", switchCase.Name)
+		}
+		names[switchCase.Name] = true
+
+		if err = switchCase.patterns.Set(switchCase.Patterns, switchCase.Match); err != nil {
+			return nil, fmt.Errorf("Invalid patterns for switch codec case '%s': %s", switchCase.Name, err)
+		}
+
+		if err = config.InitCodecs(fmt.Sprintf("%scases[%d]/codecs/", configPath, k), switchCase.Codecs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = config.InitCodecs(configPath+"default/", result.Default); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing
+func (f *CodecSwitchFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	c := &CodecSwitch{
+		config:       f,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+		branches:     make([]*switchBranch, 0, len(f.Cases)+1),
+	}
+
+	for k := range f.Cases {
+		c.branches = append(c.branches, &switchBranch{
+			name:  f.Cases[k].Name,
+			chain: NewCodecChain(f.Cases[k].Codecs, c.branchCallback, offset),
+		})
+	}
+
+	c.branches = append(c.branches, &switchBranch{
+		name:  switchDefaultName,
+		chain: NewCodecChain(f.Default, c.branchCallback, offset),
+	})
+
+	return c
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback.
+// The branches are flushed whenever the branch changes, so only lines after the
+// furthest offset of any branch can be pending
+func (c *CodecSwitch) Teardown() int64 {
+	offset := c.lastOffset
+	for _, branch := range c.branches {
+		if len(branch.chain) == 0 {
+			continue
+		}
+
+		for _, codec := range branch.chain[1:] {
+			codec.Teardown()
+		}
+
+		if branchOffset := branch.chain[0].Teardown(); branchOffset > offset {
+			offset = branchOffset
+		}
+	}
+
+	return offset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecSwitch) Reset() {
+	c.lastOffset = 0
+	c.current = nil
+	for _, branch := range c.branches {
+		for _, codec := range branch.chain {
+			codec.Reset()
+		}
+	}
+}
+
+// StartPartial passes on to the codecs of every branch that the first line may
+// be part way through an event
+func (c *CodecSwitch) StartPartial() {
+	for _, branch := range c.branches {
+		StartPartial(branch.chain)
+	}
+}
+
+// NeedsHeader returns true if the codecs of any branch need the first line of
+// the file
+func (c *CodecSwitch) NeedsHeader() bool {
+	for _, branch := range c.branches {
+		if NeedsHeader(branch.chain) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeader passes the first line of the file to the codecs of every branch
+func (c *CodecSwitch) SetHeader(text string) {
+	for _, branch := range c.branches {
+		SetHeader(branch.chain, text)
+	}
+}
+
+// Flush sends any events held by the codecs of the branches to the callback
+// immediately
+func (c *CodecSwitch) Flush() {
+	for _, branch := range c.branches {
+		branch.flush()
+	}
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. The
+// line is sent to the codecs of the first case with patterns that match it, or
+// to the default codecs if there is none. Events held by the codecs of the
+// previous line's branch are flushed first when the branch changes, so that
+// events are always shipped in the order of their offsets
+func (c *CodecSwitch) Event(startOffset int64, endOffset int64, text string, fields core.Event) {
+	branch := c.branches[len(c.branches)-1]
+	for k := range c.config.Cases {
+		if c.config.Cases[k].patterns.Match(text) {
+			branch = c.branches[k]
+			break
+		}
+	}
+
+	if c.current != nil && c.current != branch {
+		c.current.flush()
+	}
+	c.current = branch
+
+	branch.matchedLines++
+
+	if len(branch.chain) == 0 {
+		c.branchCallback(startOffset, endOffset, text, fields)
+		return
+	}
+
+	branch.chain[0].Event(startOffset, endOffset, text, fields)
+}
+
+// branchCallback receives the events from the last codec of every branch
+func (c *CodecSwitch) branchCallback(startOffset int64, endOffset int64, text string, fields core.Event) {
+	c.lastOffset = endOffset
+	c.callbackFunc(startOffset, endOffset, text, fields)
+}
+
+// flush flushes the codecs of the branch in the order they are used so that
+// events flushed from one codec can be collected and flushed by the next
+func (b *switchBranch) flush() {
+	for _, codec := range b.chain {
+		codec.Flush()
+	}
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecSwitch) Meter() {
+	for _, branch := range c.branches {
+		branch.meterMatched = branch.matchedLines
+		for _, codec := range branch.chain {
+			codec.Meter()
+		}
+	}
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecSwitch) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	for k, branch := range c.branches {
+		stubs := c.config.Default
+		if k < len(c.config.Cases) {
+			stubs = c.config.Cases[k].Codecs
+		}
+
+		codecs := &admin.APIArray{}
+		for i, codec := range branch.chain {
+			if encodable := codec.APIEncodable(); encodable != nil {
+				codecs.AddEntry(stubs[i].Name, admin.NewAPIDataEntry(encodable))
+			}
+		}
+
+		branchAPI := &admin.APIKeyValue{}
+		branchAPI.SetEntry("matched_lines", admin.APINumber(branch.meterMatched))
+		branchAPI.SetEntry("codecs", codecs)
+		api.SetEntry(branch.name, branchAPI)
+	}
+	return api
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("switch", NewSwitchCodecFactory)
+}
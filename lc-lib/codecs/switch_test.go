@@ -0,0 +1,180 @@
+package codecs
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var switchEvents []containerEvent
+
+func createSwitchCodec(unused map[string]interface{}, callback CallbackFunc, t *testing.T) Codec {
+	config := config.NewConfig()
+	config.General.SpoolMaxBytes = 10485760
+
+	factory, err := NewSwitchCodecFactory(config, "", unused, "switch")
+	if err != nil {
+		t.Errorf("Failed to create switch codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+func checkSwitch(startOffset int64, endOffset int64, text string, fields core.Event) {
+	switchEvents = append(switchEvents, containerEvent{startOffset, endOffset, text, fields})
+}
+
+func switchConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"cases": []interface{}{
+			map[string]interface{}{
+				"name":     "audit",
+				"patterns": []interface{}{"^{"},
+				"codecs": []interface{}{
+					map[string]interface{}{"name": "json"},
+				},
+			},
+			map[string]interface{}{
+				"name":     "trace",
+				"patterns": []interface{}{"^(TRACE|\\s+at )"},
+				"codecs": []interface{}{
+					map[string]interface{}{
+						"name":     "multiline",
+						"patterns": []interface{}{"^\\s+at "},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	switchEvents = nil
+
+	codec := createSwitchCodec(switchConfig(), checkSwitch, t)
+
+	codec.Event(0, 1, "Plain line", nil)
+	codec.Event(2, 3, `{"message":"Audit line","user":"jane"}`, nil)
+	codec.Event(4, 5, "TRACE Request failed", nil)
+	codec.Event(6, 7, "  at Handler.handle", nil)
+	codec.Event(8, 9, "  at Server.serve", nil)
+
+	if len(switchEvents) != 2 {
+		t.Fatalf("Wrong event count received: %d", len(switchEvents))
+	}
+
+	// The pending trace must be shipped before a line from another branch
+	codec.Event(10, 11, "Another plain line", nil)
+
+	expect := []containerEvent{
+		{0, 1, "Plain line", nil},
+		{2, 3, "Audit line", core.Event{"user": "jane"}},
+		{4, 9, "TRACE Request failed\n  at Handler.handle\n  at Server.serve", nil},
+		{10, 11, "Another plain line", nil},
+	}
+
+	if len(switchEvents) != len(expect) {
+		t.Fatalf("Wrong event count received: %d", len(switchEvents))
+	}
+
+	for i, event := range switchEvents {
+		if event.start != expect[i].start || event.end != expect[i].end {
+			t.Errorf("Wrong offsets for event %d: %d-%d", i, event.start, event.end)
+		}
+		if event.text != expect[i].text {
+			t.Errorf("Wrong message for event %d: %s", i, event.text)
+		}
+		if event.fields["user"] != expect[i].fields["user"] {
+			t.Errorf("Wrong fields for event %d: %v", i, event.fields)
+		}
+	}
+
+	if offset := codec.Teardown(); offset != 11 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestSwitchTeardownPending(t *testing.T) {
+	switchEvents = nil
+
+	codec := createSwitchCodec(switchConfig(), checkSwitch, t)
+
+	codec.Event(0, 1, "Plain line", nil)
+	codec.Event(2, 3, "TRACE Request failed", nil)
+	codec.Event(4, 5, "  at Handler.handle", nil)
+
+	// The trace has not been shipped so must be read again on resume
+	if offset := codec.Teardown(); offset != 1 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestSwitchFlush(t *testing.T) {
+	switchEvents = nil
+
+	codec := createSwitchCodec(switchConfig(), checkSwitch, t)
+
+	codec.Event(0, 1, "TRACE Request failed", nil)
+	codec.Event(2, 3, "  at Handler.handle", nil)
+	codec.Flush()
+
+	if len(switchEvents) != 1 {
+		t.Fatalf("Wrong event count received: %d", len(switchEvents))
+	}
+
+	if offset := codec.Teardown(); offset != 3 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
+func TestSwitchAPI(t *testing.T) {
+	switchEvents = nil
+
+	codec := createSwitchCodec(switchConfig(), checkSwitch, t)
+
+	codec.Event(0, 1, "Plain line", nil)
+	codec.Event(2, 3, "Not JSON {", nil)
+	codec.Event(4, 5, "{ Not JSON", nil)
+	codec.Meter()
+
+	encoded, err := codec.APIEncodable().(*admin.APIKeyValue).MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to encode API: %s", err)
+	}
+
+	expected := `{"audit":{"codecs":[{"failed_lines":1}],"matched_lines":1},` +
+		`"default":{"codecs":null,"matched_lines":2},` +
+		`"trace":{"codecs":[{"pending_bytes":0,"pending_lines":0}],"matched_lines":0}}`
+	if string(encoded) != expected {
+		t.Errorf("Wrong API encoding: %s", encoded)
+	}
+}
+
+func TestSwitchInvalidConfig(t *testing.T) {
+	config := config.NewConfig()
+
+	for _, unused := range []map[string]interface{}{
+		{},
+		{"cases": []interface{}{map[string]interface{}{"name": "empty"}}},
+		{"cases": []interface{}{
+			map[string]interface{}{"name": "default", "patterns": []interface{}{"^{"}},
+		}},
+		{"cases": []interface{}{
+			map[string]interface{}{"name": "one", "patterns": []interface{}{"^{"}},
+			map[string]interface{}{"name": "one", "patterns": []interface{}{"^\\["}},
+		}},
+		{"cases": []interface{}{
+			map[string]interface{}{
+				"patterns": []interface{}{"^{"},
+				"codecs":   []interface{}{map[string]interface{}{"name": "unknown"}},
+			},
+		}},
+	} {
+		if _, err := NewSwitchCodecFactory(config, "", unused, "switch"); err == nil {
+			t.Errorf("Invalid configuration did not fail: %v", unused)
+		}
+	}
+}
@@ -16,6 +16,8 @@
 
 package config
 
+import "fmt"
+
 // CodecRegistrarFunc is a callback that can be registered that will validate
 // the configuration settings for a codec registered via RegisterCodec
 type CodecRegistrarFunc func(*Config, string, map[string]interface{}, string) (interface{}, error)
@@ -36,3 +38,20 @@ func AvailableCodecs() (ret []string) {
 	}
 	return
 }
+
+// InitCodecs creates the factories for a list of codec configurations, such as
+// the codecs of a stream, or the codecs nested within another codec
+func (c *Config) InitCodecs(path string, codecs []CodecStub) (err error) {
+	for i := 0; i < len(codecs); i++ {
+		codec := &codecs[i]
+		if registrarFunc, ok := registeredCodecs[codec.Name]; ok {
+			if codec.Factory, err = registrarFunc(c, path, codec.Unused, codec.Name); err != nil {
+				return
+			}
+		} else {
+			return fmt.Errorf("Unrecognised codec '%s' for %s", codec.Name, path)
+		}
+	}
+
+	return nil
+}
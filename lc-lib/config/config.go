@@ -25,9 +25,13 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/ratelimit"
+	"golang.org/x/text/encoding"
 	"gopkg.in/op/go-logging.v1"
 )
 
@@ -41,6 +45,11 @@ var (
 )
 
 const (
+	defaultFileFingerprintSize       int64         = 1024
+	defaultFileIdentity              string        = "inode"
+	defaultFileRestartBackoff        time.Duration = 5 * time.Second
+	defaultFileRestartBackoffMax     time.Duration = 300 * time.Second
+	defaultFileType                  string        = "file"
 	defaultGeneralHost               string        = "localhost.localdomain"
 	defaultGeneralLogLevel           logging.Level = logging.INFO
 	defaultGeneralLogStdout          bool          = true
@@ -48,6 +57,7 @@ const (
 	defaultGeneralLineBufferBytes    int64         = 16384
 	defaultGeneralMaxLineBytes       int64         = 1048576
 	defaultGeneralProspectInterval   time.Duration = 10 * time.Second
+	defaultGeneralProspectMethod     string        = "poll"
 	defaultGeneralSpoolMaxBytes      int64         = 10485760
 	defaultGeneralSpoolSize          int64         = 1024
 	defaultGeneralSpoolTimeout       time.Duration = 5 * time.Second
@@ -62,9 +72,19 @@ const (
 	defaultStreamAddHostField        bool          = true
 	defaultStreamAddOffsetField      bool          = true
 	defaultStreamAddPathField        bool          = true
+	defaultStreamAddTimestampField   bool          = false
 	defaultStreamAddTimezoneField    bool          = false
+	defaultStreamCloseInactive       time.Duration = 0
 	defaultStreamCodec               string        = "plain"
+	defaultStreamCompression         string        = "none"
+	defaultStreamDeadAction          string        = "none"
 	defaultStreamDeadTime            time.Duration = 1 * time.Hour
+	defaultStreamDelimiter           string        = "\n"
+	defaultStreamEncoding            string        = "utf-8"
+	defaultStreamRateLimitAction     string        = "pause"
+	defaultStreamRateLimitBurst      time.Duration = 1 * time.Second
+	defaultStreamRateLimitScope      string        = "group"
+	defaultStreamTruncationPolicy    string        = "discard"
 )
 
 // Section is implemented by external config structures that will be
@@ -89,9 +109,11 @@ type General struct {
 	LogLevel         logging.Level          `config:"log level"`
 	LogStdout        bool                   `config:"log stdout"`
 	LogSyslog        bool                   `config:"log syslog"`
+	MaxHarvesters    int64                  `config:"max harvesters"`
 	MaxLineBytes     int64                  `config:"max line bytes"`
 	PersistDir       string                 `config:"persist directory"`
 	ProspectInterval time.Duration          `config:"prospect interval"`
+	ProspectMethod   string                 `config:"prospect method"`
 	SpoolSize        int64                  `config:"spool size"`
 	SpoolMaxBytes    int64                  `config:"spool max bytes"`
 	SpoolTimeout     time.Duration          `config:"spool timeout"`
@@ -106,6 +128,7 @@ func (gc *General) InitDefaults() {
 	gc.MaxLineBytes = defaultGeneralMaxLineBytes
 	gc.PersistDir = DefaultGeneralPersistDir
 	gc.ProspectInterval = defaultGeneralProspectInterval
+	gc.ProspectMethod = defaultGeneralProspectMethod
 	gc.SpoolSize = defaultGeneralSpoolSize
 	gc.SpoolMaxBytes = defaultGeneralSpoolMaxBytes
 	gc.SpoolTimeout = defaultGeneralSpoolTimeout
@@ -153,13 +176,36 @@ type CodecStub struct {
 
 // Stream holds the configuration for a log stream
 type Stream struct {
-	AddHostField     bool                   `config:"add host field"`
-	AddOffsetField   bool                   `config:"add offset field"`
-	AddPathField     bool                   `config:"add path field"`
-	AddTimezoneField bool                   `config:"add timezone field"`
-	Codecs           []CodecStub            `config:"codecs"`
-	DeadTime         time.Duration          `config:"dead time"`
-	Fields           map[string]interface{} `config:"fields"`
+	AddHostField      bool                   `config:"add host field"`
+	AddOffsetField    bool                   `config:"add offset field"`
+	AddPathField      bool                   `config:"add path field"`
+	AddTimestampField bool                   `config:"add timestamp field"`
+	AddTimezoneField  bool                   `config:"add timezone field"`
+	CloseInactive     time.Duration          `config:"close inactive"`
+	Codecs            []CodecStub            `config:"codecs"`
+	Compression       string                 `config:"compression"`
+	DeadAction        string                 `config:"dead action"`
+	DeadRenameDir     string                 `config:"dead rename directory"`
+	DeadRenameSuffix  string                 `config:"dead rename suffix"`
+	DeadTime          time.Duration          `config:"dead time"`
+	Delimiter         string                 `config:"delimiter"`
+	Encoding          string                 `config:"encoding"`
+	Fields            map[string]interface{} `config:"fields"`
+	RateLimitAction   string                 `config:"rate limit action"`
+	RateLimitBurst    time.Duration          `config:"rate limit burst"`
+	RateLimitBytes    int64                  `config:"rate limit bytes"`
+	RateLimitLines    int64                  `config:"rate limit lines"`
+	RateLimitScope    string                 `config:"rate limit scope"`
+	RecordLength      int64                  `config:"record length"`
+	TruncationPolicy  string                 `config:"truncation policy"`
+
+	// Charset is the character set named by Encoding, or nil if the data is
+	// already UTF-8 and needs no decoding
+	Charset encoding.Encoding
+
+	// RateLimiter is shared by all harvesters of the stream when the rate limit
+	// scope is group, and is nil otherwise or if there are no limits
+	RateLimiter *ratelimit.Limiter
 }
 
 // InitDefaults initialises the default configuration for a log stream
@@ -167,15 +213,47 @@ func (sc *Stream) InitDefaults() {
 	sc.AddHostField = defaultStreamAddHostField
 	sc.AddOffsetField = defaultStreamAddOffsetField
 	sc.AddPathField = defaultStreamAddPathField
+	sc.AddTimestampField = defaultStreamAddTimestampField
 	sc.AddTimezoneField = defaultStreamAddTimezoneField
+	sc.CloseInactive = defaultStreamCloseInactive
+	sc.Compression = defaultStreamCompression
+	sc.DeadAction = defaultStreamDeadAction
 	sc.DeadTime = defaultStreamDeadTime
+	sc.Delimiter = defaultStreamDelimiter
+	sc.Encoding = defaultStreamEncoding
+	sc.RateLimitAction = defaultStreamRateLimitAction
+	sc.RateLimitBurst = defaultStreamRateLimitBurst
+	sc.RateLimitScope = defaultStreamRateLimitScope
+	sc.TruncationPolicy = defaultStreamTruncationPolicy
 }
 
 // File holds the configuration for a set of paths that share the same stream
-// configuration
+// configuration, or for a command whose output should be harvested
 type File struct {
-	Paths  []string `config:"paths"`
-	Stream `config:",embed"`
+	Command           []string      `config:"command"`
+	ExcludePaths      []string      `config:"exclude paths"`
+	FingerprintSize   int64         `config:"fingerprint size"`
+	Identity          string        `config:"identity"`
+	MaxHarvesters     int64         `config:"max harvesters"`
+	PathFields        string        `config:"path fields"`
+	Paths             []string      `config:"paths"`
+	RestartBackoff    time.Duration `config:"restart backoff"`
+	RestartBackoffMax time.Duration `config:"restart backoff max"`
+	Type              string        `config:"type"`
+	Stream            `config:",embed"`
+
+	// PathFieldsPattern is the compiled path fields regular expression
+	PathFieldsPattern *regexp.Regexp
+}
+
+// InitDefaults initialises the default configuration for a file group. The
+// embedded stream configuration is initialised separately
+func (fc *File) InitDefaults() {
+	fc.FingerprintSize = defaultFileFingerprintSize
+	fc.Identity = defaultFileIdentity
+	fc.RestartBackoff = defaultFileRestartBackoff
+	fc.RestartBackoffMax = defaultFileRestartBackoffMax
+	fc.Type = defaultFileType
 }
 
 // Config holds all the configuration for Log Courier
@@ -280,6 +358,16 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	if c.General.MaxHarvesters < 0 {
+		err = fmt.Errorf("/general/max harvesters can not be negative")
+		return
+	}
+
+	if c.General.ProspectMethod != "poll" && c.General.ProspectMethod != "inotify" {
+		err = fmt.Errorf("The prospect method (/general/prospect method) is not recognised: %s", c.General.ProspectMethod)
+		return
+	}
+
 	if c.General.Host == "" {
 		ret, hostErr := os.Hostname()
 		if hostErr == nil {
@@ -292,7 +380,11 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 	}
 
 	// Ensure all GlobalFields are map[string]interface{}
-	if err = c.fixMapKeys("/general/global fields", c.General.GlobalFields); err != nil {
+	if err = c.FixMapKeys("/general/global fields", c.General.GlobalFields); err != nil {
+		return
+	}
+
+	if err = c.fixTags("/general/global fields", c.General.GlobalFields); err != nil {
 		return
 	}
 
@@ -333,11 +425,68 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 	}
 
 	for k := range c.Files {
-		if len(c.Files[k].Paths) == 0 {
-			err = fmt.Errorf("No paths specified for /files[%d]/", k)
+		if c.Files[k].Type == "" {
+			c.Files[k].Type = defaultFileType
+		}
+
+		switch c.Files[k].Type {
+		case "file":
+			if len(c.Files[k].Paths) == 0 {
+				err = fmt.Errorf("No paths specified for /files[%d]/", k)
+				return
+			}
+		case "exec":
+			if len(c.Files[k].Command) == 0 {
+				err = fmt.Errorf("No command specified for /files[%d]/", k)
+				return
+			}
+			if len(c.Files[k].Paths) != 0 {
+				err = fmt.Errorf("Paths can not be specified for /files[%d]/ as it has a type of exec", k)
+				return
+			}
+		default:
+			err = fmt.Errorf("The file group type (/files[%d]/type) is not recognised: %s", k, c.Files[k].Type)
 			return
 		}
 
+		if c.Files[k].Identity == "" {
+			c.Files[k].Identity = defaultFileIdentity
+		}
+		if c.Files[k].Identity != "inode" && c.Files[k].Identity != "fingerprint" {
+			err = fmt.Errorf("The file identity (/files[%d]/identity) is not recognised: %s", k, c.Files[k].Identity)
+			return
+		}
+		if c.Files[k].MaxHarvesters < 0 {
+			err = fmt.Errorf("/files[%d]/max harvesters can not be negative", k)
+			return
+		}
+		if c.Files[k].FingerprintSize < 1 {
+			err = fmt.Errorf("/files[%d]/fingerprint size must be greater than 0", k)
+			return
+		}
+
+		for _, pattern := range c.Files[k].ExcludePaths {
+			if _, err = filepath.Match(pattern, ""); err != nil {
+				err = fmt.Errorf("Invalid pattern for /files[%d]/exclude paths: %s", k, pattern)
+				return
+			}
+		}
+
+		if c.Files[k].PathFields != "" {
+			if c.Files[k].Type != "file" {
+				err = fmt.Errorf("Path fields can not be specified for /files[%d]/ as it has a type of %s", k, c.Files[k].Type)
+				return
+			}
+			if c.Files[k].PathFieldsPattern, err = regexp.Compile(c.Files[k].PathFields); err != nil {
+				err = fmt.Errorf("Invalid pattern for /files[%d]/path fields: %s", k, err)
+				return
+			}
+			if !hasNamedCapture(c.Files[k].PathFieldsPattern) {
+				err = fmt.Errorf("/files[%d]/path fields must contain at least one named capture group", k)
+				return
+			}
+		}
+
 		if err = c.initStreamConfig(fmt.Sprintf("/files[%d]", k), &c.Files[k].Stream, initFactories); err != nil {
 			return
 		}
@@ -357,9 +506,92 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 	return
 }
 
+// hasNamedCapture returns true if the regular expression contains at least one
+// named capture group
+func hasNamedCapture(pattern *regexp.Regexp) bool {
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+	return false
+}
+
 // initStreamConfig initialises a stream configuration by creating the necessary
 // codec factories the harvesters will require
 func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactories bool) (err error) {
+	if streamConfig.Compression == "" {
+		streamConfig.Compression = defaultStreamCompression
+	}
+	if streamConfig.Compression != "none" && streamConfig.Compression != "auto" && streamConfig.Compression != "gzip" && streamConfig.Compression != "bzip2" {
+		return fmt.Errorf("The compression method (%s/compression) is not recognised: %s", path, streamConfig.Compression)
+	}
+
+	if streamConfig.DeadAction == "" {
+		streamConfig.DeadAction = defaultStreamDeadAction
+	}
+	if streamConfig.DeadAction != "none" && streamConfig.DeadAction != "delete" && streamConfig.DeadAction != "rename" {
+		return fmt.Errorf("The dead action (%s/dead action) is not recognised: %s", path, streamConfig.DeadAction)
+	}
+	if streamConfig.DeadAction == "rename" && streamConfig.DeadRenameDir == "" && streamConfig.DeadRenameSuffix == "" {
+		return fmt.Errorf("A dead action of rename requires %s/dead rename directory or %s/dead rename suffix", path, path)
+	}
+
+	if streamConfig.CloseInactive < 0 {
+		return fmt.Errorf("The close inactive (%s/close inactive) can not be negative", path)
+	}
+
+	if streamConfig.RateLimitLines < 0 {
+		return fmt.Errorf("The rate limit lines (%s/rate limit lines) can not be negative", path)
+	}
+	if streamConfig.RateLimitBytes < 0 {
+		return fmt.Errorf("The rate limit bytes (%s/rate limit bytes) can not be negative", path)
+	}
+	if streamConfig.RateLimitBurst <= 0 {
+		streamConfig.RateLimitBurst = defaultStreamRateLimitBurst
+	}
+	if streamConfig.RateLimitAction == "" {
+		streamConfig.RateLimitAction = defaultStreamRateLimitAction
+	}
+	if streamConfig.RateLimitAction != "pause" && streamConfig.RateLimitAction != "drop" {
+		return fmt.Errorf("The rate limit action (%s/rate limit action) is not recognised: %s", path, streamConfig.RateLimitAction)
+	}
+	if streamConfig.RateLimitScope == "" {
+		streamConfig.RateLimitScope = defaultStreamRateLimitScope
+	}
+	if streamConfig.RateLimitScope != "group" && streamConfig.RateLimitScope != "file" {
+		return fmt.Errorf("The rate limit scope (%s/rate limit scope) is not recognised: %s", path, streamConfig.RateLimitScope)
+	}
+	if streamConfig.RateLimitScope == "group" {
+		streamConfig.RateLimiter = ratelimit.NewLimiter(streamConfig.RateLimitLines, streamConfig.RateLimitBytes, streamConfig.RateLimitBurst)
+	}
+
+	if streamConfig.RecordLength < 0 {
+		return fmt.Errorf("The record length (%s/record length) can not be negative", path)
+	}
+	if streamConfig.RecordLength > c.General.MaxLineBytes {
+		return fmt.Errorf("%s/record length can not be greater than /general/max line bytes", path)
+	}
+	if streamConfig.RecordLength == 0 && streamConfig.Delimiter == "" {
+		return fmt.Errorf("The delimiter (%s/delimiter) can not be empty unless a record length is specified", path)
+	}
+
+	if streamConfig.Encoding == "" {
+		streamConfig.Encoding = defaultStreamEncoding
+	}
+	charset, ok := availableEncodings[strings.ToLower(streamConfig.Encoding)]
+	if !ok {
+		return fmt.Errorf("The encoding (%s/encoding) is not recognised: %s", path, streamConfig.Encoding)
+	}
+	streamConfig.Charset = charset
+
+	if streamConfig.TruncationPolicy == "" {
+		streamConfig.TruncationPolicy = defaultStreamTruncationPolicy
+	}
+	if streamConfig.TruncationPolicy != "discard" && streamConfig.TruncationPolicy != "flush" {
+		return fmt.Errorf("The truncation policy (%s/truncation policy) is not recognised: %s", path, streamConfig.TruncationPolicy)
+	}
+
 	if !initFactories {
 		// Currently only codec factory is initialised, so skip if we're not doing that
 		return nil
@@ -369,19 +601,16 @@ func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactori
 		streamConfig.Codecs = []CodecStub{CodecStub{Name: defaultStreamCodec}}
 	}
 
-	for i := 0; i < len(streamConfig.Codecs); i++ {
-		codec := &streamConfig.Codecs[i]
-		if registrarFunc, ok := registeredCodecs[codec.Name]; ok {
-			if codec.Factory, err = registrarFunc(c, path, codec.Unused, codec.Name); err != nil {
-				return
-			}
-		} else {
-			return fmt.Errorf("Unrecognised codec '%s' for %s", codec.Name, path)
-		}
+	if err = c.InitCodecs(path, streamConfig.Codecs); err != nil {
+		return
 	}
 
 	// Ensure all Fields are map[string]interface{}
-	if err = c.fixMapKeys(path+"/fields", streamConfig.Fields); err != nil {
+	if err = c.FixMapKeys(path+"/fields", streamConfig.Fields); err != nil {
+		return
+	}
+
+	if err = c.fixTags(path+"/fields", streamConfig.Fields); err != nil {
 		return
 	}
 
@@ -400,16 +629,16 @@ func (c *Config) Get(name string) interface{} {
 	return ret
 }
 
-// fixMapKeys converts any map entries where the keys are interface{} values
+// FixMapKeys converts any map entries where the keys are interface{} values
 // into map entries where the key is a string. It returns an error if any key is
 // found that is not a string.
 // This is important as json.Encode will not encode a map where the keys are not
 // concrete strings.
-func (c *Config) fixMapKeys(path string, value map[string]interface{}) error {
+func (c *Config) FixMapKeys(path string, value map[string]interface{}) error {
 	for k, v := range value {
 		switch vt := v.(type) {
 		case map[string]interface{}:
-			if err := c.fixMapKeys(path+"/"+k, vt); err != nil {
+			if err := c.FixMapKeys(path+"/"+k, vt); err != nil {
 				return err
 			}
 		case map[interface{}]interface{}:
@@ -436,7 +665,7 @@ func (c *Config) fixMapInterfaceKeys(path string, value map[interface{}]interfac
 
 		switch vt := v.(type) {
 		case map[string]interface{}:
-			if err := c.fixMapKeys(path+"/"+ks, vt); err != nil {
+			if err := c.FixMapKeys(path+"/"+ks, vt); err != nil {
 				return nil, err
 			}
 
@@ -456,6 +685,36 @@ func (c *Config) fixMapInterfaceKeys(path string, value map[interface{}]interfac
 	return fixedMap, nil
 }
 
+// fixTags converts a "tags" entry in the given fields into an array of strings,
+// so that tags added to events are always merged into a single array. A single
+// string becomes an array containing just that string, and anything other than
+// a string or an array of strings returns an error
+func (c *Config) fixTags(path string, fields map[string]interface{}) error {
+	value, ok := fields["tags"]
+	if !ok {
+		return nil
+	}
+
+	switch vt := value.(type) {
+	case string:
+		fields["tags"] = []string{vt}
+		return nil
+	case []string:
+		return nil
+	case []interface{}:
+		tags := make([]string, len(vt))
+		for k, tag := range vt {
+			if tags[k], ok = tag.(string); !ok {
+				return fmt.Errorf("Invalid non-string tag at %s/tags[%d]", path, k)
+			}
+		}
+		fields["tags"] = tags
+		return nil
+	}
+
+	return fmt.Errorf("Option %s/tags must be a string or an array of strings", path)
+}
+
 // RegisterConfigSection registers a new Section creator which will be used to
 // create new sections that will be available via Get() in all created Config
 // structures
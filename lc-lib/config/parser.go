@@ -303,6 +303,16 @@ func (c *Config) populateValue(vField reflect.Value, vValue reflect.Value, confi
 		return
 	}
 
+	if vField.Kind() == reflect.Float64 {
+		if vValue.Kind() == reflect.Int {
+			vField.Set(reflect.ValueOf(float64(vValue.Int())))
+			return
+		}
+
+		err = fmt.Errorf("Option %s%s is not a valid number", configPath, tag)
+		return
+	}
+
 	panic(fmt.Sprintf("Unrecognised configuration structure encountered: %s (Kind: %s)", vField.Type().Name(), vField.Kind().String()))
 }
 
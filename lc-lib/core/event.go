@@ -16,7 +16,15 @@
 
 package core
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
+
+// TimestampLayout is the layout of timestamps in events, such as the
+// "@timestamp" field, which is ISO8601 in UTC with millisecond precision as
+// expected by Logstash
+const TimestampLayout = "2006-01-02T15:04:05.000Z"
 
 // Event holds a key-value map that represents a single log event
 type Event map[string]interface{}
@@ -33,3 +41,56 @@ type EventDescriptor struct {
 func (e Event) Encode() ([]byte, error) {
 	return json.Marshal(e)
 }
+
+// FormatTimestamp formats a time for use in an Event
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampLayout)
+}
+
+// Copy returns a shallow copy of the Event
+func (e Event) Copy() Event {
+	ret := make(Event, len(e))
+	for k, v := range e {
+		ret[k] = v
+	}
+	return ret
+}
+
+// AddTag appends a tag to the "tags" entry of the Event, creating it if it
+// does not exist, so that all tags are merged into a single array. A tag that
+// is already present is not added again. A new slice is always created so that
+// tags configured in the "fields" option are not modified. If "tags" is a
+// single string it becomes the first entry of a new array, and if it is any
+// other value, such as one decoded by a codec, it is kept as the first entry
+func (e Event) AddTag(tag string) {
+	switch tags := e["tags"].(type) {
+	case nil:
+		e["tags"] = []string{tag}
+	case string:
+		if tags == tag {
+			e["tags"] = []string{tags}
+		} else {
+			e["tags"] = []string{tags, tag}
+		}
+	case []string:
+		for _, existing := range tags {
+			if existing == tag {
+				return
+			}
+		}
+		newTags := make([]string, len(tags), len(tags)+1)
+		copy(newTags, tags)
+		e["tags"] = append(newTags, tag)
+	case []interface{}:
+		for _, existing := range tags {
+			if existing == tag {
+				return
+			}
+		}
+		newTags := make([]interface{}, len(tags), len(tags)+1)
+		copy(newTags, tags)
+		e["tags"] = append(newTags, tag)
+	default:
+		e["tags"] = []interface{}{tags, tag}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventAddTag(t *testing.T) {
+	configured := []string{"first"}
+
+	for _, check := range []struct {
+		tags     interface{}
+		expected interface{}
+	}{
+		{nil, []string{"new"}},
+		{"first", []string{"first", "new"}},
+		{"new", []string{"new"}},
+		{configured, []string{"first", "new"}},
+		{[]string{"new"}, []string{"new"}},
+		{[]interface{}{"first", 1}, []interface{}{"first", 1, "new"}},
+		{[]interface{}{"new"}, []interface{}{"new"}},
+		{42, []interface{}{42, "new"}},
+		{map[string]interface{}{"a": "b"}, []interface{}{map[string]interface{}{"a": "b"}, "new"}},
+	} {
+		event := Event{"tags": check.tags}
+		event.AddTag("new")
+
+		if !reflect.DeepEqual(event["tags"], check.expected) {
+			t.Errorf("Wrong tags after adding to %#v: %#v", check.tags, event["tags"])
+		}
+	}
+
+	if !reflect.DeepEqual(configured, []string{"first"}) {
+		t.Errorf("Existing tags were modified: %v", configured)
+	}
+
+	event := Event{}
+	event.AddTag("new")
+	if !reflect.DeepEqual(event["tags"], []string{"new"}) {
+		t.Errorf("Wrong tags after adding to an event without tags: %#v", event["tags"])
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// CompressionNone means the file is read as-is
+	CompressionNone = "none"
+	// CompressionAuto means the compression is chosen from the file extension
+	CompressionAuto = "auto"
+	// CompressionGzip means the file is decompressed using gzip
+	CompressionGzip = "gzip"
+	// CompressionBzip2 means the file is decompressed using bzip2
+	CompressionBzip2 = "bzip2"
+)
+
+// CompressionForPath returns the compression method to use for the given path
+// when the stream configuration specifies the given method. When the method is
+// "auto" the file extension decides which, if any, decompression is required
+func CompressionForPath(path string, method string) string {
+	if method != CompressionAuto {
+		return method
+	}
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		return CompressionGzip
+	case ".bz2":
+		return CompressionBzip2
+	}
+
+	return CompressionNone
+}
+
+// newDecompressor returns a reader that decompresses the data from the given
+// reader using the given compression method
+func newDecompressor(rd io.Reader, method string) (io.Reader, error) {
+	switch method {
+	case CompressionNone:
+		return rd, nil
+	case CompressionGzip:
+		return gzip.NewReader(rd)
+	case CompressionBzip2:
+		return bzip2.NewReader(rd), nil
+	}
+
+	return nil, fmt.Errorf("Unknown compression method: %s", method)
+}
+
+// ReadDecompressed returns up to size bytes from the start of a file after
+// decompressing it using the given compression method
+func ReadDecompressed(path string, method string, size int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	source, err := newDecompressor(file, method)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	n, err := io.ReadFull(source, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	return data[:n], err
+}
@@ -0,0 +1,67 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package harvester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestCompressionForPath(t *testing.T) {
+	checks := []struct {
+		path, method, expected string
+	}{
+		{"/var/log/messages", CompressionAuto, CompressionNone},
+		{"/var/log/messages.1.gz", CompressionAuto, CompressionGzip},
+		{"/var/log/messages.1.bz2", CompressionAuto, CompressionBzip2},
+		{"/var/log/messages.1.gz", CompressionNone, CompressionNone},
+		{"/var/log/messages", CompressionGzip, CompressionGzip},
+	}
+
+	for _, check := range checks {
+		if result := CompressionForPath(check.path, check.method); result != check.expected {
+			t.Errorf("Wrong compression for %s (%s): %s != %s", check.path, check.method, result, check.expected)
+		}
+	}
+}
+
+func TestCompressionGzipLineRead(t *testing.T) {
+	data := new(bytes.Buffer)
+	writer := gzip.NewWriter(data)
+	writer.Write([]byte("12345678901234567890\n12345678901234567890\n"))
+	writer.Close()
+
+	source, err := newDecompressor(data, CompressionGzip)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	reader := NewLineReader(source, 100, 100)
+
+	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
+	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestCompressionUnknown(t *testing.T) {
+	if _, err := newDecompressor(new(bytes.Buffer), "zip"); err == nil {
+		t.Error("Unknown compression method was accepted")
+	}
+}
@@ -23,7 +23,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +33,8 @@ import (
 	"github.com/driskell/log-courier/lc-lib/codecs"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/ratelimit"
+	"golang.org/x/text/encoding"
 )
 
 var (
@@ -44,12 +48,24 @@ var (
 // FinishStatus contains the final file state, and any errors, from the point the
 // harvester finished
 type FinishStatus struct {
-	LastEventOffset int64
-	LastReadOffset  int64
-	Error           error
-	LastStat        os.FileInfo
+	LastEventOffset   int64
+	LastReadOffset    int64
+	LastShippedOffset int64
+	Error             error
+	LastStat          os.FileInfo
+	// Dead is true if the harvester stopped because the file was complete or
+	// had not changed within dead time, and no data remains buffered
+	Dead bool
+	// Inactive is true if the harvester closed the file because it had not
+	// changed within close inactive, and no data remains buffered
+	Inactive bool
 }
 
+// FinishFunc is called when a harvester reading from a stream reaches EOF. It
+// can return a message and fields for a final event, or an empty message if
+// there is no final event to ship
+type FinishFunc func() (string, map[string]interface{})
+
 // Harvester reads from a file, passes lines through a codec, and sends them
 // for spooling
 type Harvester struct {
@@ -67,15 +83,31 @@ type Harvester struct {
 	codec           codecs.Codec
 	codecChain      []codecs.Codec
 	file            *os.File
+	source          io.Reader
+	compression     string
 	backOffTimer    *time.Timer
 	meterTimer      *time.Timer
 	split           bool
+	truncated       bool
 	timezone        string
 	reader          *LineReader
+	decoder         *encoding.Decoder
+	delimiter       string
+	trimCR          bool
 	staleOffset     int64
 	staleBytes      int64
 	lastStaleOffset int64
 	isStream        bool
+	isDead          bool
+	isInactive      bool
+	fields          map[string]interface{}
+	finish          FinishFunc
+	limiter         *ratelimit.Limiter
+	throttled       bool
+	dropStart       int64
+	dropEnd         int64
+
+	lastShippedOffset int64
 
 	lastReadTime         time.Time
 	lastMeasurement      time.Time
@@ -88,23 +120,60 @@ type Harvester struct {
 	byteSpeed  float64
 	lineCount  uint64
 	byteCount  uint64
+	dropCount  uint64
 	lastEOFOff *int64
 	lastEOF    *time.Time
 	lastSize   int64
 	lastOffset int64
+
+	lastThrottled    bool
+	lastDroppedCount uint64
+}
+
+// NewHarvester creates a new harvester with the given configuration for the
+// given stream identifier. The given fields, which may be nil, are added to
+// every event
+func NewHarvester(stream core.Stream, config *config.Config, streamConfig *config.Stream, offset int64, fields map[string]interface{}) *Harvester {
+	var ret *Harvester
+	if stream == nil {
+		// This is stdin
+		ret = newHarvester(nil, config, streamConfig, offset, Stdin, nil, os.Stdin)
+	} else {
+		// Grab now so we can safely use them even if prospector changes them
+		path, fileinfo := stream.Info()
+		ret = newHarvester(stream, config, streamConfig, offset, path, fileinfo, nil)
+	}
+
+	ret.fields = fields
+	return ret
+}
+
+// NewStreamHarvester creates a new harvester that reads from the given file
+// until EOF, such as a pipe from a running command, instead of following a file
+// on disk. The name is used in place of the path, and the given fields are
+// added to every event. If finish is not nil it is called when EOF is reached
+func NewStreamHarvester(stream core.Stream, config *config.Config, streamConfig *config.Stream, name string, file *os.File, fields map[string]interface{}, finish FinishFunc) *Harvester {
+	ret := newHarvester(stream, config, streamConfig, 0, name, nil, file)
+	ret.fields = fields
+	ret.finish = finish
+	return ret
 }
 
-// NewHarvester creates a new harvester with the given configuration for the given stream identifier
-func NewHarvester(stream core.Stream, config *config.Config, streamConfig *config.Stream, offset int64) *Harvester {
+// newHarvester creates a new harvester for a file on disk, or for a stream if
+// the given file is not nil
+func newHarvester(stream core.Stream, config *config.Config, streamConfig *config.Stream, offset int64, path string, fileinfo os.FileInfo, file *os.File) *Harvester {
 	ret := &Harvester{
 		stopChan:     make(chan interface{}),
 		stream:       stream,
+		path:         path,
+		fileinfo:     fileinfo,
+		file:         file,
+		isStream:     file != nil,
 		config:       config,
 		streamConfig: streamConfig,
 		offset:       offset,
 		timezone:     time.Now().Format("-0700 MST"),
 		lastEOF:      nil,
-		codecChain:   make([]codecs.Codec, len(streamConfig.Codecs)-1),
 		backOffTimer: time.NewTimer(0),
 		// TODO: Configurable meter timer? Use same as statCheck timer
 		meterTimer: time.NewTimer(10 * time.Second),
@@ -112,32 +181,34 @@ func NewHarvester(stream core.Stream, config *config.Config, streamConfig *confi
 
 	ret.backOffTimer.Stop()
 
-	if stream != nil {
-		// Grab now so we can safely use them even if prospector changes them
-		ret.path, ret.fileinfo = stream.Info()
-		ret.isStream = false
+	ret.compression = CompressionForPath(ret.path, streamConfig.Compression)
+
+	if streamConfig.Charset != nil {
+		ret.decoder = streamConfig.Charset.NewDecoder()
+	}
+
+	if streamConfig.RateLimitScope == "file" {
+		ret.limiter = ratelimit.NewLimiter(streamConfig.RateLimitLines, streamConfig.RateLimitBytes, streamConfig.RateLimitBurst)
 	} else {
-		// This is stdin
-		ret.file = os.Stdin
-		ret.path, ret.fileinfo = Stdin, nil
-		ret.isStream = true
+		ret.limiter = streamConfig.RateLimiter
 	}
 
 	// Build the codec chain
-	var entry codecs.Codec
-	callback := ret.eventCallback
-	for i := len(streamConfig.Codecs) - 1; i >= 0; i-- {
-		entry = codecs.NewCodec(streamConfig.Codecs[i].Factory, callback, ret.offset)
-		callback = entry.Event
-		if i != 0 {
-			ret.codecChain[i-1] = entry
-		}
-	}
-	ret.codec = entry
+	chain := codecs.NewCodecChain(streamConfig.Codecs, ret.eventCallback, ret.offset)
+	ret.codec = chain[0]
+	ret.codecChain = chain[1:]
 
 	return ret
 }
 
+// StartPartial notes that the harvester's offset may be part way through an
+// event, such as the end of a file that existed before startup, so the codecs
+// can skip the remainder of that event. It must be called before Start
+func (h *Harvester) StartPartial() {
+	codecs.StartPartial([]codecs.Codec{h.codec})
+	codecs.StartPartial(h.codecChain)
+}
+
 // Start runs the harvester, sending events to the output given, and returns
 // immediately
 func (h *Harvester) Start(output chan<- *core.EventDescriptor) {
@@ -152,7 +223,15 @@ func (h *Harvester) Start(output chan<- *core.EventDescriptor) {
 		status := &FinishStatus{}
 		status.LastEventOffset, status.Error = h.harvest(output)
 		status.LastReadOffset = h.offset
+		status.LastShippedOffset = h.lastShippedOffset
 		status.LastStat = h.fileinfo
+		status.Dead = h.isDead && status.Error == nil
+		status.Inactive = h.isInactive && status.Error == nil
+		if (status.Dead || status.Inactive) && (status.LastEventOffset != status.LastReadOffset || h.reader.BufferedLen() != 0) {
+			log.Info("Data is still buffered for %s so it will not be considered complete", h.path)
+			status.Dead = false
+			status.Inactive = false
+		}
 		h.returnChan <- status
 		close(h.returnChan)
 	}()
@@ -192,8 +271,17 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 		log.Info("Started harvester: %s", h.path)
 		h.offset = 0
 	} else {
-		// Get current offset in file
-		offset, err := h.file.Seek(0, os.SEEK_CUR)
+		// Codecs such as CSV in header mode need the first line of the file
+		// when starting part way through it
+		if h.offset != 0 && (codecs.NeedsHeader([]codecs.Codec{h.codec}) || codecs.NeedsHeader(h.codecChain)) {
+			if err := h.readHeader(); err != nil {
+				log.Warning("Failed to read the first line of %s: %s", h.path, err)
+				return h.offset, err
+			}
+		}
+
+		// Move to the requested offset in the file
+		offset, err := h.seekSource()
 		if err != nil {
 			log.Warning("Failed to determine start offset for %s: %s", h.path, err)
 			return h.offset, err
@@ -208,8 +296,12 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 		h.offset = offset
 	}
 
-	// The buffer size limits the maximum line length we can read, including terminator
-	h.reader = NewLineReader(h.file, int(h.config.General.LineBufferBytes), int(h.config.General.MaxLineBytes))
+	h.lastShippedOffset = h.offset
+
+	if err := h.prepareReader(); err != nil {
+		log.Errorf("Failed to encode line delimiter for %s: %s", h.path, err)
+		return h.offset, err
+	}
 
 	// Prepare internal data
 	h.lastReadTime = time.Now()
@@ -226,7 +318,86 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 	}
 
 	log.Info("Harvester for %s exiting", h.path)
-	return h.codecTeardown(), nil
+
+	// Lines dropped by the rate limit after the last event are complete too,
+	// otherwise the file would never be considered dead and they would be read
+	// again when harvesting resumes
+	lastEventOffset := h.codecTeardown()
+	if lastEventOffset == h.dropStart && h.dropEnd > lastEventOffset {
+		lastEventOffset = h.dropEnd
+	}
+
+	return lastEventOffset, nil
+}
+
+// readHeader reads the first line of the file and passes it to the codecs that
+// need it, and then returns to the start of the file
+func (h *Harvester) readHeader() error {
+	if err := h.prepareReader(); err != nil {
+		return err
+	}
+
+	line, err := h.reader.ReadSlice()
+	if err == nil {
+		var text string
+		if h.decoder != nil {
+			text, _, _ = h.decodeLine(line, nil)
+		} else {
+			text = string(line[:len(line)-len(h.delimiter)])
+			if h.trimCR {
+				text = strings.TrimSuffix(text, "\r")
+			}
+		}
+
+		codecs.SetHeader([]codecs.Codec{h.codec}, text)
+		codecs.SetHeader(h.codecChain, text)
+	} else if err != io.EOF && err != ErrLineTooLong {
+		return err
+	}
+
+	if _, err = h.file.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+
+	h.source, err = newDecompressor(h.file, h.compression)
+	return err
+}
+
+// prepareReader creates the line reader, configuring it to split either on
+// the delimiter, in the file's encoding, or into fixed length records
+func (h *Harvester) prepareReader() error {
+	// The buffer size limits the maximum line length we can read, including terminator
+	h.reader = NewLineReader(h.source, int(h.config.General.LineBufferBytes), int(h.config.General.MaxLineBytes))
+
+	if h.streamConfig.RecordLength != 0 {
+		h.reader.SetRecordLength(int(h.streamConfig.RecordLength))
+		return nil
+	}
+
+	h.delimiter = h.streamConfig.Delimiter
+	// Mixed line endings are only handled for the default delimiter
+	h.trimCR = h.delimiter == "\n"
+
+	if h.streamConfig.Charset == nil {
+		h.reader.SetDelimiter([]byte(h.delimiter), 1)
+		return nil
+	}
+
+	// The length of an encoded new line tells us the width of a character in
+	// the encoding (such as 2 for UTF-16)
+	encoder := h.streamConfig.Charset.NewEncoder()
+	newline, err := encoder.Bytes([]byte("\n"))
+	if err != nil {
+		return err
+	}
+
+	delim, err := encoder.Bytes([]byte(h.delimiter))
+	if err != nil {
+		return err
+	}
+
+	h.reader.SetDelimiter(delim, len(newline))
+	return nil
 }
 
 // performRead performs a single read operation
@@ -245,11 +416,30 @@ func (h *Harvester) performRead() error {
 	}
 
 	if err == nil {
+		if h.limiter != nil {
+			allowed, limitErr := h.rateLimit(bytesread)
+			if limitErr != nil {
+				return limitErr
+			}
+			if !allowed {
+				// Remember the range of lines dropped since the last line passed
+				// to the codecs, so it can be included in the final offset
+				if h.dropEnd != h.offset {
+					h.dropStart = h.offset
+				}
+				h.offset += int64(bytesread)
+				h.dropEnd = h.offset
+				h.dropCount++
+				h.split = false
+				return nil
+			}
+		}
+
 		lineOffset := h.offset
 		h.offset += int64(bytesread)
 
 		// Codec is last - it forwards harvester state for us such as offset for resume
-		h.codec.Event(lineOffset, h.offset, text)
+		h.codec.Event(lineOffset, h.offset, text, h.newEvent(lineOffset))
 
 		h.lastReadTime = time.Now()
 		h.lineCount++
@@ -265,6 +455,14 @@ func (h *Harvester) performRead() error {
 	if h.isStream {
 		// Stream has finished
 		log.Info("Stopping harvest of %s; EOF reached", h.path)
+		h.finishStream()
+		return errStopRequested
+	}
+
+	if h.compression != CompressionNone {
+		// Compressed files are always complete so there is nothing to wait for
+		log.Info("Stopping harvest of %s; end of compressed data reached", h.path)
+		h.isDead = true
 		return errStopRequested
 	}
 
@@ -287,23 +485,96 @@ func (h *Harvester) performRead() error {
 	return nil
 }
 
+// rateLimit applies the rate limit to a line of the given length. With the
+// pause action it waits until the line is within the limit, and with the drop
+// action it returns false if the line should be discarded
+func (h *Harvester) rateLimit(length int) (bool, error) {
+	if h.streamConfig.RateLimitAction == "drop" {
+		if h.limiter.Allow(length) {
+			return true, nil
+		}
+		h.throttled = true
+		return false, nil
+	}
+
+	wait := h.limiter.Reserve(length)
+	if wait == 0 {
+		return true, nil
+	}
+
+	h.throttled = true
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-h.stopChan:
+		return false, errStopRequested
+	case <-timer.C:
+	}
+
+	return true, nil
+}
+
 func (h *Harvester) handleTruncation() {
 	log.Warning("Unexpected file truncation, seeking to beginning: %s", h.path)
 
+	if h.streamConfig.TruncationPolicy == "flush" {
+		h.flushTruncated()
+	} else if h.reader.BufferedLen() != 0 {
+		log.Errorf("%d bytes of incomplete log data was lost due to file truncation", h.reader.BufferedLen())
+	}
+
 	h.file.Seek(0, os.SEEK_SET)
 	h.offset = 0
 	h.staleOffset = 0
 	h.lastStaleOffset = 0
-
-	// TODO: Should we be allowing truncation to lose buffer data? Or should
-	//       we be flushing what we have?
-	if h.reader.BufferedLen() != 0 {
-		log.Errorf("%d bytes of incomplete log data was lost due to file truncation", h.reader.BufferedLen())
-	}
+	h.dropStart = 0
+	h.dropEnd = 0
 
 	// Reset line buffer and codec buffers
 	h.reader.Reset()
 	h.codec.Reset()
+	for _, codec := range h.codecChain {
+		codec.Reset()
+	}
+}
+
+// flushTruncated ships any incomplete line in the buffer, followed by any
+// events held by the codecs, so that they are not lost when the file is
+// truncated. The events are tagged "truncated"
+func (h *Harvester) flushTruncated() {
+	h.truncated = true
+
+	if line := h.reader.Flush(); line != nil {
+		log.Warning("Flushing %d bytes of incomplete log data due to file truncation: %s", len(line), h.path)
+
+		var text string
+		if h.decoder != nil {
+			text, _, _ = h.decodeLine(line, io.EOF)
+		} else {
+			text = string(line)
+		}
+		if h.trimCR {
+			text = strings.TrimSuffix(text, "\r")
+		}
+
+		lineOffset := h.offset
+		h.offset += int64(len(line))
+		h.codec.Event(lineOffset, h.offset, text, h.newEvent(lineOffset))
+
+		h.lineCount++
+		h.byteCount += uint64(len(line))
+	}
+
+	// Flush in the order the codecs are used so that events flushed from one
+	// codec can be collected and flushed by the next
+	h.codec.Flush()
+	for _, codec := range h.codecChain {
+		codec.Flush()
+	}
+
+	h.truncated = false
 }
 
 func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked bool) error {
@@ -337,7 +608,7 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 
 	if doChecks && !h.isStream {
 		var err error
-		if err = h.statCheck(); err != nil {
+		if err = h.statCheck(isPipelineBlocked); err != nil {
 			return err
 		}
 	}
@@ -347,6 +618,8 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	h.byteSpeed = core.CalculateSpeed(duration, h.byteSpeed, float64(h.byteCount-h.lastByteCount), &h.secondsWithoutEvents)
 	h.lastByteCount = h.byteCount
 	h.lastLineCount = h.lineCount
+	h.lastThrottled = h.throttled
+	h.lastDroppedCount = h.dropCount
 	h.lastOffset = h.offset
 	if h.fileinfo != nil {
 		h.lastSize = h.fileinfo.Size()
@@ -365,6 +638,8 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	}
 	h.mutex.Unlock()
 
+	h.throttled = false
+
 	// Check shutdown
 	select {
 	case <-h.stopChan:
@@ -376,14 +651,18 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 }
 
 // statCheck checks for truncation and returns the file size of the file
-func (h *Harvester) statCheck() error {
+// The file is never considered dead while the pipeline is blocked, as the
+// last read time will not have moved forward while waiting for it
+func (h *Harvester) statCheck(isPipelineBlocked bool) error {
 	info, err := h.file.Stat()
 	if err != nil {
 		log.Errorf("Unexpected error checking status of %s: %s", h.path, err)
 		return err
 	}
 
-	if info.Size() < h.offset {
+	// Offsets into compressed files are for the uncompressed data so cannot be
+	// compared with the file size
+	if h.compression == CompressionNone && info.Size() < h.offset {
 		return errFileTruncated
 	}
 
@@ -392,9 +671,23 @@ func (h *Harvester) statCheck() error {
 	// race where we hit EOF but as we Stat() the mtime is updated - this mtime
 	// is the one we monitor in order to resume checking, so we need to check it
 	// didn't already update
-	if age := time.Since(h.lastReadTime); age > h.streamConfig.DeadTime && h.fileinfo.ModTime() == info.ModTime() {
+	if age := time.Since(h.lastReadTime); !isPipelineBlocked && age > h.streamConfig.DeadTime && h.fileinfo.ModTime() == info.ModTime() {
 		log.Info("Stopping harvest of %s; last change was %v ago", h.path, age-(age%time.Second))
-		// TODO: dead_action implementation here
+		h.isDead = true
+		return errStopRequested
+	}
+
+	// Release the file early if it has not changed for close inactive, the
+	// prospector will start harvesting again if it changes
+	if age := time.Since(h.lastReadTime); !isPipelineBlocked && h.streamConfig.CloseInactive != 0 && age > h.streamConfig.CloseInactive && h.fileinfo.ModTime() == info.ModTime() {
+		log.Info("Closing %s; last change was %v ago", h.path, age-(age%time.Second))
+		h.isInactive = true
+		return errStopRequested
+	}
+
+	// Release deleted files as soon as everything in them has been read
+	if !isPipelineBlocked && h.compression == CompressionNone && isDeleted(info) && h.offset+int64(h.reader.BufferedLen()) >= info.Size() {
+		log.Info("Stopping harvest of %s; file was deleted", h.path)
 		return errStopRequested
 	}
 
@@ -405,44 +698,18 @@ func (h *Harvester) statCheck() error {
 }
 
 // eventCallback receives events from the final codec and ships them to the output
-func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text string) {
-	event := core.Event{
-		"message": text,
-	}
-
-	if h.streamConfig.AddHostField {
-		event["host"] = h.config.General.Host
-	}
-	if h.streamConfig.AddPathField {
-		event["path"] = h.path
-	}
-	if h.streamConfig.AddOffsetField {
-		event["offset"] = startOffset
-	}
-	if h.streamConfig.AddTimezoneField {
-		event["timezone"] = h.timezone
+func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text string, fields core.Event) {
+	// Fields take precedence over the message, so a configured "message" field
+	// will replace the line, as will a "message" field decoded by a codec
+	event := make(core.Event, len(fields)+1)
+	event["message"] = text
+	for k := range fields {
+		event[k] = fields[k]
 	}
 
-	for k := range h.config.General.GlobalFields {
-		event[k] = h.config.General.GlobalFields[k]
-	}
-
-	for k := range h.streamConfig.Fields {
-		event[k] = h.streamConfig.Fields[k]
-	}
-
-	// If we split any of the line data, tag it
-	if h.split {
-		if v, ok := event["tags"]; ok {
-			va, ok := v.([]string)
-			if ok {
-				va = append(va, "splitline")
-				event["tags"] = va
-			}
-		} else {
-			event["tags"] = []string{"splitline"}
-		}
-		h.split = false
+	// Tag events flushed early due to truncation
+	if h.truncated {
+		event.AddTag("truncated")
 	}
 
 	encoded, err := event.Encode()
@@ -464,6 +731,7 @@ EventLoop:
 		case <-h.stopChan:
 			break EventLoop
 		case h.output <- desc:
+			h.lastShippedOffset = endOffset
 			break EventLoop
 		case <-h.meterTimer.C:
 			// TODO: Configurable meter timer? Same as statCheck?
@@ -479,13 +747,77 @@ EventLoop:
 	}
 }
 
+// newEvent returns the fields for a line read at the given offset, which pass
+// through the codecs along with the line itself
+func (h *Harvester) newEvent(offset int64) core.Event {
+	event := core.Event{}
+
+	if h.streamConfig.AddHostField {
+		event["host"] = h.config.General.Host
+	}
+	if h.streamConfig.AddPathField {
+		event["path"] = h.path
+	}
+	if h.streamConfig.AddOffsetField {
+		event["offset"] = offset
+	}
+	if h.streamConfig.AddTimezoneField {
+		event["timezone"] = h.timezone
+	}
+	if h.streamConfig.AddTimestampField {
+		event["@timestamp"] = core.FormatTimestamp(time.Now())
+	}
+
+	for k := range h.config.General.GlobalFields {
+		event[k] = h.config.General.GlobalFields[k]
+	}
+
+	for k := range h.streamConfig.Fields {
+		event[k] = h.streamConfig.Fields[k]
+	}
+
+	for k := range h.fields {
+		event[k] = h.fields[k]
+	}
+
+	// If we split any of the line data, tag it
+	if h.split {
+		event.AddTag("splitline")
+		h.split = false
+	}
+
+	return event
+}
+
+// finishStream ships the final event for a stream, if there is one
+func (h *Harvester) finishStream() {
+	if h.finish == nil {
+		return
+	}
+
+	text, fields := h.finish()
+	if text == "" {
+		return
+	}
+
+	event := h.newEvent(h.offset)
+	for k := range fields {
+		event[k] = fields[k]
+	}
+
+	// This is not from the stream so does not pass through the codecs
+	h.eventCallback(h.offset, h.offset, text, event)
+}
+
 func (h *Harvester) prepareHarvester() error {
+	var err error
+
 	// Streams don't need opening or checking
 	if h.isStream {
-		return nil
+		h.source, err = newDecompressor(h.file, h.compression)
+		return err
 	}
 
-	var err error
 	h.file, err = h.openFile(h.path)
 	if err != nil {
 		log.Errorf("Failed opening %s: %s", h.path, err)
@@ -507,12 +839,31 @@ func (h *Harvester) prepareHarvester() error {
 	// Store latest stat()
 	h.fileinfo = info
 
-	// TODO: Check error?
-	h.file.Seek(h.offset, os.SEEK_SET)
+	h.source, err = newDecompressor(h.file, h.compression)
+	if err != nil {
+		h.file.Close()
+		return err
+	}
 
 	return nil
 }
 
+// seekSource moves to the resume offset and returns the offset reached.
+// Compressed data cannot be seeked, so the uncompressed data preceding the
+// offset is read and discarded instead
+func (h *Harvester) seekSource() (int64, error) {
+	if h.compression == CompressionNone {
+		return h.file.Seek(h.offset, os.SEEK_SET)
+	}
+
+	skipped, err := io.CopyN(ioutil.Discard, h.source, h.offset)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return skipped, err
+}
+
 // readline reads a single line from the file, handling mixed line endings
 // and detecting where lines were split due to being too big for the buffer
 func (h *Harvester) readline() (string, int, error) {
@@ -520,13 +871,17 @@ func (h *Harvester) readline() (string, int, error) {
 
 	line, err := h.reader.ReadSlice()
 
+	if line != nil && h.decoder != nil {
+		return h.decodeLine(line, err)
+	}
+
 	if line != nil {
 		if err == nil {
-			// Line will always end in '\n' if no error, but check also for CR
-			if len(line) > 1 && line[len(line)-2] == '\r' {
-				newline = 2
-			} else {
-				newline = 1
+			// Line will always end in the delimiter if no error, but check also
+			// for CR when the delimiter is a new line
+			newline = len(h.delimiter)
+			if h.trimCR && len(line) > 1 && line[len(line)-2] == '\r' {
+				newline++
 			}
 		} else if err == ErrLineTooLong {
 			h.split = true
@@ -556,6 +911,37 @@ func (h *Harvester) readline() (string, int, error) {
 	return "", 0, io.EOF
 }
 
+// decodeLine converts a line read from a file that is not UTF-8 into UTF-8,
+// returning the line without its line ending, and the length of the original
+// line including its line ending
+func (h *Harvester) decodeLine(line []byte, err error) (string, int, error) {
+	decoded, decodeErr := h.decoder.Bytes(line)
+	if decodeErr != nil {
+		log.Warning("Failed to decode line in %s at offset %d: %s", h.path, h.offset, decodeErr)
+		decoded = line
+	}
+
+	text := string(decoded)
+
+	if err == nil {
+		// Line ending was already checked so can be removed, along with any CR
+		text = strings.TrimSuffix(text, h.delimiter)
+		if h.trimCR {
+			text = strings.TrimSuffix(text, "\r")
+		}
+	} else if err == ErrLineTooLong {
+		h.split = true
+		err = nil
+	}
+
+	// Remove any byte order mark from the very start of the file
+	if h.offset == 0 {
+		text = strings.TrimPrefix(text, "\uFEFF")
+	}
+
+	return text, len(line), err
+}
+
 // APIEncodable returns an admin API entry with harvester status
 func (h *Harvester) APIEncodable() admin.APIEncodable {
 	h.mutex.RLock()
@@ -590,6 +976,15 @@ func (h *Harvester) APIEncodable() admin.APIEncodable {
 		}
 	}
 
+	if h.limiter != nil {
+		rateLimit := &admin.APIKeyValue{}
+		rateLimit.SetEntry("action", admin.APIString(h.streamConfig.RateLimitAction))
+		rateLimit.SetEntry("scope", admin.APIString(h.streamConfig.RateLimitScope))
+		rateLimit.SetEntry("throttled", admin.APIBoolean(h.lastThrottled))
+		rateLimit.SetEntry("dropped_lines", admin.APINumber(h.lastDroppedCount))
+		apiEncodable.SetEntry("rate_limit", rateLimit)
+	}
+
 	codecs := &admin.APIArray{}
 	i := 0
 	if encodable := h.codec.APIEncodable(); encodable != nil {
@@ -20,8 +20,17 @@ package harvester
 
 import (
 	"os"
+	"syscall"
 )
 
 func (h *Harvester) openFile(path string) (*os.File, error) {
 	return os.Open(path)
 }
+
+// isDeleted returns true if the open file no longer has any links to it
+func isDeleted(info os.FileInfo) bool {
+	if fstat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fstat.Nlink == 0
+	}
+	return false
+}
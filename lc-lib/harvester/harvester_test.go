@@ -0,0 +1,313 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package harvester
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// testStream identifies a file being harvested by a test
+type testStream struct {
+	path string
+}
+
+func (s *testStream) Info() (string, os.FileInfo) {
+	info, _ := os.Stat(s.path)
+	return s.path, info
+}
+
+// newTestConfig returns a configuration with a single stream using the plain
+// codec, after allowing it to be customised
+func newTestConfig(t *testing.T, configure func(*config.Stream)) (*config.Config, *config.Stream) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.Codecs = []config.CodecStub{{Name: "plain"}}
+	if configure != nil {
+		configure(streamConfig)
+	}
+
+	if err := cfg.InitCodecs("/stdin/codecs/", streamConfig.Codecs); err != nil {
+		t.Fatalf("Failed to initialise codecs: %s", err)
+	}
+
+	return cfg, streamConfig
+}
+
+// writeTestFile writes data to a new file in a temporary directory, returning
+// the path of the file and the directory, which should be removed afterwards
+func writeTestFile(t *testing.T, data []byte) (string, string) {
+	dir, err := ioutil.TempDir("", "harvester")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+
+	path := filepath.Join(dir, "test.log")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+
+	return path, dir
+}
+
+// receiveEvents waits for the given number of events from a harvester
+func receiveEvents(t *testing.T, output <-chan *core.EventDescriptor, count int) []core.Event {
+	events := make([]core.Event, 0, count)
+	timeout := time.After(5 * time.Second)
+
+	for len(events) < count {
+		select {
+		case desc := <-output:
+			event := core.Event{}
+			if err := json.Unmarshal(desc.Event, &event); err != nil {
+				t.Fatalf("Failed to decode event: %s", err)
+			}
+			events = append(events, event)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for events, received %d of %d", len(events), count)
+		}
+	}
+
+	return events
+}
+
+func TestHarvesterUTF16(t *testing.T) {
+	for _, check := range []struct {
+		name  string
+		order unicode.Endianness
+	}{
+		{"utf-16le", unicode.LittleEndian},
+		{"utf-16be", unicode.BigEndian},
+	} {
+		charset := unicode.UTF16(check.order, unicode.IgnoreBOM)
+		data, err := charset.NewEncoder().Bytes([]byte("\uFEFFfirst line\r\nsecond ünïcode line\n"))
+		if err != nil {
+			t.Fatalf("Failed to encode test data: %s", err)
+		}
+
+		path, dir := writeTestFile(t, data)
+		defer os.RemoveAll(dir)
+
+		cfg, streamConfig := newTestConfig(t, func(streamConfig *config.Stream) {
+			streamConfig.Encoding = check.name
+			streamConfig.Charset = charset
+		})
+
+		output := make(chan *core.EventDescriptor, 10)
+		h := NewHarvester(&testStream{path}, cfg, streamConfig, 0, nil)
+		h.Start(output)
+
+		events := receiveEvents(t, output, 2)
+		if events[0]["message"] != "first line" {
+			t.Errorf("Wrong first line for %s: %q", check.name, events[0]["message"])
+		}
+		if events[1]["message"] != "second ünïcode line" {
+			t.Errorf("Wrong second line for %s: %q", check.name, events[1]["message"])
+		}
+
+		h.Stop()
+		status := <-h.OnFinish()
+		if status.Error != nil {
+			t.Errorf("Harvester for %s failed: %s", check.name, status.Error)
+		}
+		if status.LastEventOffset != int64(len(data)) {
+			t.Errorf("Wrong last event offset for %s: %d", check.name, status.LastEventOffset)
+		}
+	}
+}
+
+func TestHarvesterRateLimitDrop(t *testing.T) {
+	data := []byte("first\nsecond\nthird\n")
+	path, dir := writeTestFile(t, data)
+	defer os.RemoveAll(dir)
+
+	cfg, streamConfig := newTestConfig(t, func(streamConfig *config.Stream) {
+		streamConfig.RateLimitLines = 1
+		streamConfig.RateLimitBurst = time.Second
+		streamConfig.RateLimitAction = "drop"
+		streamConfig.RateLimitScope = "file"
+	})
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(&testStream{path}, cfg, streamConfig, 0, nil)
+	h.Start(output)
+
+	events := receiveEvents(t, output, 1)
+	if events[0]["message"] != "first" {
+		t.Errorf("Wrong line shipped: %q", events[0]["message"])
+	}
+
+	// Wait for the remaining lines to be read and dropped
+	timeout := time.Now().Add(5 * time.Second)
+	for {
+		h.mutex.Lock()
+		atEOF := h.lastEOFOff != nil && *h.lastEOFOff == int64(len(data))
+		h.mutex.Unlock()
+		if atEOF {
+			break
+		}
+		if time.Now().After(timeout) {
+			t.Fatalf("Timed out waiting for the harvester to reach the end of the file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h.Stop()
+	status := <-h.OnFinish()
+	if status.Error != nil {
+		t.Fatalf("Harvester failed: %s", status.Error)
+	}
+	if len(output) != 0 {
+		t.Errorf("Dropped lines were shipped")
+	}
+	if status.LastEventOffset != int64(len(data)) || status.LastReadOffset != int64(len(data)) {
+		t.Errorf("Dropped lines were not included in the offsets: event=%d read=%d", status.LastEventOffset, status.LastReadOffset)
+	}
+	if status.LastShippedOffset != 6 {
+		t.Errorf("Wrong last shipped offset: %d", status.LastShippedOffset)
+	}
+}
+
+func TestHarvesterCSVHeaderResume(t *testing.T) {
+	data := []byte("name,count\r\napples,3\r\npears,5\r\n")
+	path, dir := writeTestFile(t, data)
+	defer os.RemoveAll(dir)
+
+	cfg, streamConfig := newTestConfig(t, func(streamConfig *config.Stream) {
+		streamConfig.Codecs = []config.CodecStub{{Name: "csv", Unused: map[string]interface{}{"header": true}}}
+	})
+
+	// Resume after the first line following the header
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(&testStream{path}, cfg, streamConfig, 22, nil)
+	h.Start(output)
+
+	events := receiveEvents(t, output, 1)
+	if events[0]["name"] != "pears" || events[0]["count"] != "5" {
+		t.Errorf("Columns were not named from the header: %v", events[0])
+	}
+	if _, ok := events[0]["tags"]; ok {
+		t.Errorf("Line after resuming was tagged: %v", events[0]["tags"])
+	}
+
+	h.Stop()
+	status := <-h.OnFinish()
+	if status.Error != nil {
+		t.Fatalf("Harvester failed: %s", status.Error)
+	}
+	if status.LastEventOffset != int64(len(data)) {
+		t.Errorf("Wrong last event offset: %d", status.LastEventOffset)
+	}
+}
+
+// openTestHarvester opens a harvester on a file without starting it, so that
+// the periodic checks can be run directly
+func openTestHarvester(t *testing.T, path string, streamConfig *config.Stream, cfg *config.Config) *Harvester {
+	h := NewHarvester(&testStream{path}, cfg, streamConfig, 0, nil)
+	if err := h.prepareHarvester(); err != nil {
+		t.Fatalf("Failed to open %s: %s", path, err)
+	}
+	if err := h.prepareReader(); err != nil {
+		h.file.Close()
+		t.Fatalf("Failed to prepare reader for %s: %s", path, err)
+	}
+	h.lastReadTime = time.Now()
+	return h
+}
+
+func TestHarvesterCloseInactive(t *testing.T) {
+	path, dir := writeTestFile(t, []byte("line\n"))
+	defer os.RemoveAll(dir)
+
+	cfg, streamConfig := newTestConfig(t, func(streamConfig *config.Stream) {
+		streamConfig.CloseInactive = time.Minute
+	})
+	h := openTestHarvester(t, path, streamConfig, cfg)
+	defer h.file.Close()
+	h.offset = 5
+
+	if err := h.statCheck(false); err != nil {
+		t.Fatalf("Recently read file was closed: %v", err)
+	}
+
+	// Not while the pipeline is blocked
+	h.lastReadTime = time.Now().Add(-2 * time.Minute)
+	if err := h.statCheck(true); err != nil {
+		t.Fatalf("File was closed while the pipeline was blocked: %v", err)
+	}
+
+	if err := h.statCheck(false); err != errStopRequested {
+		t.Fatalf("Inactive file was not closed: %v", err)
+	}
+	if !h.isInactive || h.isDead {
+		t.Errorf("Inactive file was not flagged as inactive: inactive=%t dead=%t", h.isInactive, h.isDead)
+	}
+
+	// Disabled by default
+	cfg, streamConfig = newTestConfig(t, nil)
+	h = openTestHarvester(t, path, streamConfig, cfg)
+	defer h.file.Close()
+	h.offset = 5
+	h.lastReadTime = time.Now().Add(-2 * time.Minute)
+
+	if err := h.statCheck(false); err != nil || h.isInactive {
+		t.Errorf("File was closed without close inactive: %v", err)
+	}
+}
+
+func TestHarvesterReleasesDeletedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Open files cannot be deleted on Windows")
+	}
+
+	path, dir := writeTestFile(t, []byte("line\n"))
+	defer os.RemoveAll(dir)
+
+	cfg, streamConfig := newTestConfig(t, nil)
+	h := openTestHarvester(t, path, streamConfig, cfg)
+	defer h.file.Close()
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove %s: %s", path, err)
+	}
+
+	// Unread data keeps the file open
+	if err := h.statCheck(false); err != nil {
+		t.Fatalf("Deleted file was released before it was read: %v", err)
+	}
+
+	h.offset = 5
+	if err := h.statCheck(false); err != errStopRequested {
+		t.Fatalf("Deleted file was not released: %v", err)
+	}
+	if h.isDead || h.isInactive {
+		t.Errorf("Deleted file was flagged as dead or inactive")
+	}
+}
@@ -40,3 +40,9 @@ func (h *Harvester) openFile(path string) (*os.File, error) {
 
 	return os.NewFile(uintptr(handle), path), nil
 }
+
+// isDeleted always returns false on Windows as there is no link count to check
+// and a file pending deletion can not be told apart from one that was renamed
+func isDeleted(info os.FileInfo) bool {
+	return false
+}
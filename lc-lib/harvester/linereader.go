@@ -39,6 +39,11 @@ type LineReader struct {
 	start    int
 	end      int
 	err      error
+	delim    []byte
+	width    int
+
+	recordLen int
+	curRecord int
 }
 
 // NewLineReader creates a new line reader structure reading from the given
@@ -55,16 +60,56 @@ func NewLineReader(rd io.Reader, size int, maxLine int) *LineReader {
 		size:    size,
 		maxLine: maxLine,
 		curMax:  maxLine,
+		delim:   []byte{'\n'},
+		width:   1,
 	}
 
 	return lr
 }
 
+// SetDelimiter changes the byte sequence that terminates each line. Width is
+// the size in bytes of a single character of the data's encoding. Delimiters
+// are only matched when they are aligned to a character boundary and lines
+// that are too long are only cut on a character boundary, so that multi-byte
+// encodings such as UTF-16 are not split in the middle of a character
+func (lr *LineReader) SetDelimiter(delim []byte, width int) {
+	lr.delim = delim
+	lr.width = width
+	lr.recordLen = 0
+}
+
+// SetRecordLength switches the linereader to returning fixed length records of
+// the given number of bytes instead of delimited lines. Data at the end that
+// is shorter than a record remains buffered until the rest arrives
+func (lr *LineReader) SetRecordLength(length int) {
+	lr.delim = nil
+	lr.width = 1
+	lr.recordLen = length
+	lr.curRecord = length
+}
+
 // Reset the linereader, still using the same io.Reader, but as if it had just
 // being constructed. This will cause any currently buffered data to be lost
 func (lr *LineReader) Reset() {
 	lr.start = 0
 	lr.end = 0
+	lr.overflow = nil
+	lr.curMax = lr.maxLine
+	lr.curRecord = lr.recordLen
+}
+
+// Flush returns all currently buffered data that has not yet been returned as
+// a line, and then resets the linereader. It returns nil if there is no
+// buffered data
+func (lr *LineReader) Flush() []byte {
+	var line []byte
+	if lr.overflow != nil || lr.end != lr.start {
+		lr.overflow = append(lr.overflow, lr.buf[lr.start:lr.end])
+		line = bytes.Join(lr.overflow, []byte{})
+	}
+
+	lr.Reset()
+	return line
 }
 
 // BufferedLen returns the current number of bytes sitting in the buffer
@@ -86,9 +131,9 @@ func (lr *LineReader) ReadSlice() ([]byte, error) {
 	}
 
 	for {
-		if n := bytes.IndexByte(lr.buf[lr.start:lr.end], '\n'); n >= 0 && n < lr.curMax {
-			line = lr.buf[lr.start : lr.start+n+1]
-			lr.start += n + 1
+		if n := lr.indexDelim(); n >= 0 && n+len(lr.delim) <= lr.curMax {
+			line = lr.buf[lr.start : lr.start+n+len(lr.delim)]
+			lr.start += n + len(lr.delim)
 			err = nil
 			break
 		}
@@ -98,20 +143,37 @@ func (lr *LineReader) ReadSlice() ([]byte, error) {
 		}
 
 		if lr.end-lr.start >= lr.curMax {
-			line = lr.buf[lr.start : lr.start+lr.curMax]
-			lr.start += lr.curMax
+			// Cut on a character boundary
+			cut := lr.curMax - lr.curMax%lr.width
+			if cut == 0 {
+				cut = lr.curMax
+			}
+			line = lr.buf[lr.start : lr.start+cut]
+			lr.start += cut
 			err = ErrLineTooLong
 			break
 		}
 
 		if lr.end-lr.start >= len(lr.buf) {
-			lr.start, lr.end = 0, 0
+			// Keep back enough bytes to find a delimiter that is split across
+			// the boundary, and keep what we move a whole number of characters
+			keep := 0
+			if len(lr.delim) > 1 {
+				keep = len(lr.delim) - 1
+			}
+			keep += (len(lr.buf) - keep) % lr.width
+			moved := len(lr.buf) - keep
+
 			if lr.overflow == nil {
 				lr.overflow = make([][]byte, 0, 1)
 			}
-			lr.overflow = append(lr.overflow, lr.buf)
-			lr.curMax -= len(lr.buf)
-			lr.buf = make([]byte, lr.size)
+			lr.overflow = append(lr.overflow, lr.buf[:moved])
+			lr.curMax -= moved
+			lr.curRecord -= moved
+			newBuf := make([]byte, lr.size)
+			copy(newBuf, lr.buf[moved:])
+			lr.buf = newBuf
+			lr.start, lr.end = 0, keep
 		}
 
 		err = lr.fill()
@@ -122,10 +184,44 @@ func (lr *LineReader) ReadSlice() ([]byte, error) {
 		line = bytes.Join(lr.overflow, []byte{})
 		lr.overflow = nil
 		lr.curMax = lr.maxLine
+		lr.curRecord = lr.recordLen
 	}
 	return line, err
 }
 
+// indexDelim returns the position of the first delimiter in the buffer that
+// starts on a character boundary, or -1 if there is none. For fixed length
+// records it returns the position of the end of the record once the record is
+// complete
+func (lr *LineReader) indexDelim() int {
+	if lr.recordLen != 0 {
+		if lr.end-lr.start >= lr.curRecord {
+			return lr.curRecord
+		}
+		return -1
+	}
+
+	buf := lr.buf[lr.start:lr.end]
+	if len(lr.delim) == 1 && lr.width == 1 {
+		return bytes.IndexByte(buf, lr.delim[0])
+	}
+
+	pos := 0
+	for {
+		n := bytes.Index(buf[pos:], lr.delim)
+		if n < 0 {
+			return -1
+		}
+
+		n += pos
+		if n%lr.width == 0 {
+			return n
+		}
+
+		pos = n + 1
+	}
+}
+
 // fill reads from the reader and fills the buffer, shifting all unread bytes to
 // the front of the buffer to make room
 func (lr *LineReader) fill() error {
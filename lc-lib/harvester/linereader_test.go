@@ -123,3 +123,117 @@ func TestLineReadTooLong(t *testing.T) {
 	checkLine(t, reader, nil, io.EOF)
 	checkBufferedLen(t, reader, 0)
 }
+
+func TestLineReadWideDelimiter(t *testing.T) {
+	// UTF-16LE where the second line contains "\n\x00" across a character
+	// boundary, which must not be treated as a line ending
+	data := bytes.NewBuffer([]byte{'a', 0, 'b', 0, '\n', 0, 0x31, 0x0A, 0x00, 0x30, '\n', 0})
+
+	reader := NewLineReader(data, 100, 100)
+	reader.SetDelimiter([]byte{'\n', 0}, 2)
+
+	checkLine(t, reader, []byte{'a', 0, 'b', 0, '\n', 0}, nil)
+	checkLine(t, reader, []byte{0x31, 0x0A, 0x00, 0x30, '\n', 0}, nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadWideDelimiterOverflow(t *testing.T) {
+	data := bytes.NewBuffer([]byte{'a', 0, 'b', 0, 'c', 0, '\n', 0, 'd', 0, '\n', 0})
+
+	// Odd buffer size so the delimiter is split across buffer boundaries
+	reader := NewLineReader(data, 5, 100)
+	reader.SetDelimiter([]byte{'\n', 0}, 2)
+
+	checkLine(t, reader, []byte{'a', 0, 'b', 0, 'c', 0, '\n', 0}, nil)
+	checkLine(t, reader, []byte{'d', 0, '\n', 0}, nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadWideDelimiterTooLong(t *testing.T) {
+	data := bytes.NewBuffer([]byte{'a', 0, 'b', 0, 'c', 0, '\n', 0})
+
+	// Odd max line length so the cut must move back to a character boundary
+	reader := NewLineReader(data, 100, 5)
+	reader.SetDelimiter([]byte{'\n', 0}, 2)
+
+	checkLine(t, reader, []byte{'a', 0, 'b', 0}, ErrLineTooLong)
+	checkLine(t, reader, []byte{'c', 0, '\n', 0}, nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadFlush(t *testing.T) {
+	data := bytes.NewBufferString("12345678901234567890\n123456")
+
+	reader := NewLineReader(data, 100, 100)
+
+	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
+	checkLine(t, reader, nil, io.EOF)
+
+	if line := reader.Flush(); !bytes.Equal(line, []byte("123456")) {
+		t.Errorf("Flushed data incorrect: [% X]", line)
+	}
+	checkBufferedLen(t, reader, 0)
+
+	if line := reader.Flush(); line != nil {
+		t.Errorf("Flushed data was not expected: [% X]", line)
+	}
+}
+
+func TestLineReadFlushOverflow(t *testing.T) {
+	data := bytes.NewBufferString("12345678901234567890\n1234567890123456789012345")
+
+	// New line read with 10 bytes buffer so the incomplete line overflows
+	reader := NewLineReader(data, 10, 100)
+
+	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
+	checkLine(t, reader, nil, io.EOF)
+
+	if line := reader.Flush(); !bytes.Equal(line, []byte("1234567890123456789012345")) {
+		t.Errorf("Flushed data incorrect: [% X]", line)
+	}
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadCustomDelimiter(t *testing.T) {
+	data := bytes.NewBufferString("12345678901234567890\x0012345678901234567890\x00123")
+
+	reader := NewLineReader(data, 100, 100)
+	reader.SetDelimiter([]byte{0}, 1)
+
+	checkLine(t, reader, []byte("12345678901234567890\x00"), nil)
+	checkLine(t, reader, []byte("12345678901234567890\x00"), nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 3)
+}
+
+func TestLineReadRecordLength(t *testing.T) {
+	data := bytes.NewBufferString("1234567890\n234567890123456789012345")
+
+	reader := NewLineReader(data, 100, 100)
+	reader.SetRecordLength(10)
+
+	checkLine(t, reader, []byte("1234567890"), nil)
+	checkLine(t, reader, []byte("\n234567890"), nil)
+	checkLine(t, reader, []byte("1234567890"), nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 5)
+}
+
+func TestLineReadRecordLengthOverflow(t *testing.T) {
+	data := bytes.NewBufferString("123456789012345678901234567890123")
+
+	// New line read with 8 bytes buffer so each record overflows
+	reader := NewLineReader(data, 8, 100)
+	reader.SetRecordLength(15)
+
+	checkLine(t, reader, []byte("123456789012345"), nil)
+	checkLine(t, reader, []byte("678901234567890"), nil)
+	checkLine(t, reader, nil, io.EOF)
+
+	if line := reader.Flush(); !bytes.Equal(line, []byte("123")) {
+		t.Errorf("Flushed data incorrect: [% X]", line)
+	}
+}
@@ -37,6 +37,12 @@ func (a *apiStatus) Update() error {
 	a.p.mutex.RLock()
 	a.SetEntry("watchedFiles", admin.APINumber(len(a.p.prospectorindex)))
 	a.SetEntry("activeStates", admin.APINumber(len(a.p.prospectors)))
+	a.SetEntry("method", admin.APIString(a.p.method))
+	a.SetEntry("runningHarvesters", admin.APINumber(a.p.numRunning))
+	a.SetEntry("queuedHarvesters", admin.APINumber(len(a.p.queue)))
+	if a.p.watcher != nil {
+		a.SetEntry("watchedDirectories", admin.APINumber(a.p.watcher.NumWatched()))
+	}
 	a.p.mutex.RUnlock()
 
 	return nil
@@ -71,6 +77,10 @@ func (a *apiFiles) Update() error {
 		a.processEntry(info)
 	}
 
+	for _, runner := range a.p.execs {
+		a.AddEntry(fmt.Sprintf("%p", runner), runner.apiEncodable())
+	}
+
 	a.p.mutex.RUnlock()
 
 	return nil
@@ -100,6 +110,10 @@ func (a *apiFiles) processEntry(info *prospectorInfo) {
 	default:
 		if info.running {
 			status = "running"
+		} else if info.queued {
+			status = "queued"
+		} else if info.inactiveStatus != nil {
+			status = "inactive"
 		} else {
 			status = "dead"
 		}
@@ -0,0 +1,332 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/harvester"
+)
+
+const (
+	execStatusStarting = iota
+	execStatusRunning
+	execStatusWaiting
+	execStatusStopped
+)
+
+// execRunner runs the command for an exec file group, harvesting its output
+// and restarting it with a backoff whenever it exits
+type execRunner struct {
+	mutex sync.RWMutex
+
+	config     *config.Config
+	fileConfig *config.File
+	command    string
+	output     chan<- *core.EventDescriptor
+	backoff    *core.ExpBackoff
+	stopChan   chan interface{}
+	doneChan   chan interface{}
+
+	status       int
+	harvester    *harvester.Harvester
+	pid          int
+	starts       uint64
+	lastExitCode *int
+	err          error
+	nextStart    time.Time
+}
+
+// newExecRunner creates a new runner for the given exec file group
+func newExecRunner(config *config.Config, fileConfig *config.File, output chan<- *core.EventDescriptor) *execRunner {
+	command := strings.Join(fileConfig.Command, " ")
+
+	return &execRunner{
+		config:     config,
+		fileConfig: fileConfig,
+		command:    command,
+		output:     output,
+		backoff:    core.NewExpBackoff(command+" Restart", fileConfig.RestartBackoff, fileConfig.RestartBackoffMax),
+		stopChan:   make(chan interface{}),
+		doneChan:   make(chan interface{}),
+	}
+}
+
+// execKey returns the key used to match the runners of exec file groups when
+// the configuration is reloaded
+func execKey(fileConfig *config.File) string {
+	return strings.Join(fileConfig.Command, "\x00")
+}
+
+// reconfigure updates the configuration of the runner following a reload,
+// which takes effect the next time the command is started
+func (r *execRunner) reconfigure(config *config.Config, fileConfig *config.File) {
+	r.mutex.Lock()
+	r.config = config
+	r.fileConfig = fileConfig
+	r.mutex.Unlock()
+}
+
+// currentConfig returns the configuration of the runner
+func (r *execRunner) currentConfig() (*config.Config, *config.File) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.config, r.fileConfig
+}
+
+// Info implements core.Stream so that events can be identified as belonging
+// to this runner. There is never any file information
+func (r *execRunner) Info() (string, os.FileInfo) {
+	return r.command, nil
+}
+
+// start begins running the command in a new routine
+func (r *execRunner) start() {
+	go r.run()
+}
+
+// stop terminates the command and waits for the runner to finish
+func (r *execRunner) stop() {
+	close(r.stopChan)
+	<-r.doneChan
+}
+
+// run starts the command and restarts it when it exits until stopped
+func (r *execRunner) run() {
+	defer func() {
+		r.setStatus(execStatusStopped)
+		close(r.doneChan)
+	}()
+
+	for {
+		started := time.Now()
+		exitCode, err := r.runOnce()
+
+		select {
+		case <-r.stopChan:
+			return
+		default:
+		}
+
+		// Successful runs, and runs that lasted longer than the maximum backoff,
+		// restart after the initial delay
+		_, fileConfig := r.currentConfig()
+		if (err == nil && exitCode == 0) || time.Since(started) > fileConfig.RestartBackoffMax {
+			r.backoff.Reset()
+		}
+
+		delay := r.backoff.Trigger()
+		log.Info("Restarting command in %v: %s", delay, r.command)
+
+		r.mutex.Lock()
+		r.status = execStatusWaiting
+		r.nextStart = time.Now().Add(delay)
+		r.mutex.Unlock()
+
+		select {
+		case <-r.stopChan:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runOnce runs the command until it exits, harvesting its output, and returns
+// its exit code
+func (r *execRunner) runOnce() (int, error) {
+	r.setStatus(execStatusStarting)
+
+	config, fileConfig := r.currentConfig()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		log.Error("Failed to create pipe for command %s: %s", r.command, err)
+		r.setError(err)
+		return -1, err
+	}
+
+	cmd := exec.Command(fileConfig.Command[0], fileConfig.Command[1:]...)
+	cmd.Stdout = writer
+	configureCommand(cmd)
+
+	err = cmd.Start()
+	// Our copy of the write end must be closed so we see EOF when it exits
+	writer.Close()
+	if err != nil {
+		log.Error("Failed to start command %s: %s", r.command, err)
+		reader.Close()
+		r.setError(err)
+		return -1, err
+	}
+
+	log.Info("Started command with PID %d: %s", cmd.Process.Pid, r.command)
+
+	var waitOnce sync.Once
+	var exitCode int
+	var waitErr error
+	wait := func() {
+		waitOnce.Do(func() {
+			exitCode, waitErr = r.wait(cmd)
+		})
+	}
+
+	finish := func() (string, map[string]interface{}) {
+		wait()
+		if waitErr != nil {
+			return "", nil
+		}
+		return fmt.Sprintf("Command exited with code %d", exitCode), map[string]interface{}{"exit_code": exitCode}
+	}
+
+	fields := map[string]interface{}{"command": r.command}
+	h := harvester.NewStreamHarvester(r, config, &fileConfig.Stream, r.command, reader, fields, finish)
+
+	r.mutex.Lock()
+	r.status = execStatusRunning
+	r.harvester = h
+	r.pid = cmd.Process.Pid
+	r.starts++
+	r.err = nil
+	r.mutex.Unlock()
+
+	h.Start(r.output)
+
+	var status *harvester.FinishStatus
+	select {
+	case status = <-h.OnFinish():
+	case <-r.stopChan:
+		killCommand(cmd)
+		h.Stop()
+		status = <-h.OnFinish()
+	}
+
+	if status.Error != nil {
+		log.Error("Failed to harvest output of command %s: %s", r.command, status.Error)
+		// Ensure the command does not continue without us
+		killCommand(cmd)
+	}
+
+	wait()
+
+	r.mutex.Lock()
+	r.harvester = nil
+	r.pid = 0
+	if waitErr != nil {
+		r.err = waitErr
+		r.lastExitCode = nil
+	} else {
+		r.lastExitCode = &exitCode
+	}
+	r.mutex.Unlock()
+
+	return exitCode, waitErr
+}
+
+// wait waits for the command to exit and returns its exit code
+func (r *execRunner) wait(cmd *exec.Cmd) (int, error) {
+	err := cmd.Wait()
+	if err == nil {
+		log.Info("Command exited successfully: %s", r.command)
+		return 0, nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			log.Warning("Command exited with code %d: %s", status.ExitStatus(), r.command)
+			return status.ExitStatus(), nil
+		}
+	}
+
+	log.Error("Failed to wait for command %s: %s", r.command, err)
+	return -1, err
+}
+
+// setStatus updates the status of the runner
+func (r *execRunner) setStatus(status int) {
+	r.mutex.Lock()
+	r.status = status
+	r.mutex.Unlock()
+}
+
+// setError records a failure to start the command
+func (r *execRunner) setError(err error) {
+	r.mutex.Lock()
+	r.err = err
+	r.mutex.Unlock()
+}
+
+// apiEncodable returns the admin API entry for the runner
+func (r *execRunner) apiEncodable() *admin.APIKeyValue {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var status admin.APIString
+	switch r.status {
+	case execStatusStarting:
+		status = "starting"
+	case execStatusRunning:
+		status = "running"
+	case execStatusWaiting:
+		status = "waiting"
+	default:
+		status = "stopped"
+	}
+
+	apiEntry := &admin.APIKeyValue{}
+	apiEntry.SetEntry("id", admin.APIString(fmt.Sprintf("%p", r)))
+	apiEntry.SetEntry("command", admin.APIString(r.command))
+	apiEntry.SetEntry("type", admin.APIString("exec"))
+	apiEntry.SetEntry("status", status)
+	apiEntry.SetEntry("starts", admin.APINumber(r.starts))
+
+	if r.pid != 0 {
+		apiEntry.SetEntry("pid", admin.APINumber(r.pid))
+	} else {
+		apiEntry.SetEntry("pid", admin.APINull)
+	}
+
+	if r.lastExitCode != nil {
+		apiEntry.SetEntry("last_exit_code", admin.APINumber(*r.lastExitCode))
+	} else {
+		apiEntry.SetEntry("last_exit_code", admin.APINull)
+	}
+
+	if r.err != nil {
+		apiEntry.SetEntry("error", admin.APIString(r.err.Error()))
+	} else {
+		apiEntry.SetEntry("error", admin.APINull)
+	}
+
+	if r.status == execStatusWaiting {
+		apiEntry.SetEntry("next_start", admin.APIString(r.nextStart.Format(time.RFC3339)))
+	}
+
+	if r.harvester != nil {
+		apiEntry.SetEntry("harvester", r.harvester.APIEncodable())
+	}
+
+	return apiEntry
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// newTestExecRunner creates a runner for the given command that restarts only
+// after a long delay
+func newTestExecRunner(t *testing.T, command ...string) (*execRunner, chan *core.EventDescriptor) {
+	p, _ := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Type = "exec"
+		fileconfig.Command = command
+		fileconfig.RestartBackoff = time.Hour
+		fileconfig.RestartBackoffMax = time.Hour
+	})
+
+	output := make(chan *core.EventDescriptor, 10)
+	return newExecRunner(p.config, &p.config.Files[0], output), output
+}
+
+// receiveExecEvent waits for the next event from a runner
+func receiveExecEvent(t *testing.T, output <-chan *core.EventDescriptor) core.Event {
+	select {
+	case desc := <-output:
+		event := core.Event{}
+		if err := json.Unmarshal(desc.Event, &event); err != nil {
+			t.Fatalf("Failed to decode event: %s", err)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return nil
+}
+
+func TestExecRunner(t *testing.T) {
+	runner, output := newTestExecRunner(t, "sh", "-c", "echo hello; exit 3")
+	runner.start()
+	defer runner.stop()
+
+	event := receiveExecEvent(t, output)
+	if event["message"] != "hello" || event["command"] != "sh -c echo hello; exit 3" {
+		t.Errorf("Wrong output event: %v", event)
+	}
+
+	event = receiveExecEvent(t, output)
+	if event["message"] != "Command exited with code 3" || event["exit_code"] != float64(3) {
+		t.Errorf("Wrong exit event: %v", event)
+	}
+
+	// Wait for the runner to record the exit before it restarts
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runner.mutex.RLock()
+		status := runner.status
+		runner.mutex.RUnlock()
+		if status == execStatusWaiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Runner did not wait to restart, status %d", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	encoded, err := runner.apiEncodable().MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to encode API: %s", err)
+	}
+
+	var api map[string]interface{}
+	json.Unmarshal(encoded, &api)
+	if api["id"] == "" || api["status"] != "waiting" || api["starts"] != float64(1) || api["last_exit_code"] != float64(3) || api["pid"] != nil {
+		t.Errorf("Wrong API received: %s", encoded)
+	}
+}
+
+func TestExecRunnerStopKillsProcessGroup(t *testing.T) {
+	// The background process keeps the output open, so the harvester only
+	// finishes if it is killed too
+	runner, output := newTestExecRunner(t, "sh", "-c", "sleep 30 & echo started; wait")
+	runner.start()
+
+	if event := receiveExecEvent(t, output); event["message"] != "started" {
+		t.Errorf("Wrong output event: %v", event)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		runner.stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Runner did not stop")
+	}
+}
+
+func TestUpdateExecs(t *testing.T) {
+	p, _ := newTestProspector(t, nil)
+	p.config.Files = []config.File{
+		{Type: "exec", Command: []string{"one"}},
+		{Type: "exec", Command: []string{"two"}},
+		{Type: "file"},
+	}
+
+	execs, removed := p.updateExecs()
+	if len(execs) != 2 || len(removed) != 0 {
+		t.Fatalf("Wrong runners created: %d, %d", len(execs), len(removed))
+	}
+	p.execs = execs
+
+	// Reload with one command kept, one removed and one added
+	p.config = config.NewConfig()
+	p.config.Files = []config.File{
+		{Type: "exec", Command: []string{"three"}},
+		{Type: "exec", Command: []string{"two"}},
+	}
+
+	execs, removed = p.updateExecs()
+	if len(execs) != 2 || execs[0].command != "three" || execs[1] != p.execs[1] {
+		t.Errorf("Wrong runners after reload: %v", execs)
+	}
+	if execs[1].fileConfig != &p.config.Files[1] {
+		t.Error("Kept runner was not reconfigured")
+	}
+	if len(removed) != 1 || removed[0] != p.execs[0] {
+		t.Errorf("Wrong runners removed: %v", removed)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// globRecursive is the path component that matches zero or more directories
+const globRecursive = "**"
+
+// splitPath splits a cleaned path into its components
+func splitPath(path string) []string {
+	return strings.Split(filepath.Clean(path), string(filepath.Separator))
+}
+
+// globRoot returns the part of the pattern preceding the first recursive
+// component, and whether or not the pattern contains one
+func globRoot(pattern string) (string, bool) {
+	parts := splitPath(pattern)
+	for i, part := range parts {
+		if part != globRecursive {
+			continue
+		}
+
+		if i == 0 {
+			return ".", true
+		}
+
+		root := strings.Join(parts[:i], string(filepath.Separator))
+		if root == "" {
+			root = string(filepath.Separator)
+		}
+		return root, true
+	}
+
+	return "", false
+}
+
+// globWalk walks the directories matching the root of a recursive pattern,
+// calling the given function for each path found beneath them. Directories
+// that cannot be read are silently skipped
+func globWalk(root string, walkFn func(path string, info os.FileInfo)) error {
+	roots, err := filepath.Glob(root)
+	if err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil {
+				walkFn(path, info)
+			}
+			return nil
+		})
+	}
+
+	return nil
+}
+
+// globPaths returns the names of all files matching the pattern. It behaves as
+// filepath.Glob, except that a "**" path component matches zero or more
+// directories. Directories themselves are not returned for recursive patterns
+func globPaths(pattern string) ([]string, error) {
+	root, recursive := globRoot(pattern)
+	if !recursive {
+		return filepath.Glob(pattern)
+	}
+
+	// Catch bad patterns before walking anything
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err := globWalk(root, func(path string, info os.FileInfo) {
+		if !info.IsDir() && matchPath(pattern, path) {
+			matches = append(matches, path)
+		}
+	})
+
+	return matches, err
+}
+
+// globDirs returns the directories that may contain files matching the
+// pattern, so that they can be watched for changes
+func globDirs(pattern string) ([]string, error) {
+	root, recursive := globRoot(pattern)
+	if !recursive {
+		return filepath.Glob(filepath.Dir(pattern))
+	}
+
+	var dirs []string
+	err := globWalk(root, func(path string, info os.FileInfo) {
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+	})
+
+	return dirs, err
+}
+
+// matchPath reports whether the path matches the pattern, with the same
+// syntax as globPaths
+func matchPath(pattern string, path string) bool {
+	return matchParts(splitPath(pattern), splitPath(path))
+}
+
+// matchParts matches path components against pattern components
+func matchParts(pattern []string, path []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == globRecursive {
+			// Try consuming zero or more directories
+			for i := 0; i <= len(path); i++ {
+				if matchParts(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+
+		if matched, err := filepath.Match(pattern[0], path[0]); err != nil || !matched {
+			return false
+		}
+
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// matchExclude returns the first exclude pattern that matches the path. A
+// pattern containing no separator is matched against the file name only
+func matchExclude(patterns []string, path string) (string, bool) {
+	for _, pattern := range patterns {
+		target := path
+		if filepath.Base(pattern) == pattern {
+			target = filepath.Base(path)
+		}
+
+		if matchPath(pattern, target) {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
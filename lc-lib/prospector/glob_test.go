@@ -0,0 +1,111 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	checks := []struct {
+		pattern, path string
+		expected      bool
+	}{
+		{"/var/log/*.log", "/var/log/messages.log", true},
+		{"/var/log/*.log", "/var/log/app/messages.log", false},
+		{"/var/log/**/*.log", "/var/log/messages.log", true},
+		{"/var/log/**/*.log", "/var/log/app/messages.log", true},
+		{"/var/log/**/*.log", "/var/log/app/nested/messages.log", true},
+		{"/var/log/**/*.log", "/var/log/app/messages.txt", false},
+		{"/var/log/**/app/*.log", "/var/log/a/b/app/messages.log", true},
+		{"/var/log/**/app/*.log", "/var/log/a/b/other/messages.log", false},
+		{"/var/**", "/var/log/messages", true},
+	}
+
+	for _, check := range checks {
+		if result := matchPath(check.pattern, check.path); result != check.expected {
+			t.Errorf("Wrong match result for %s against %s: %t != %t", check.path, check.pattern, result, check.expected)
+		}
+	}
+}
+
+func TestMatchExclude(t *testing.T) {
+	patterns := []string{"*.gz", "debug-*.log", "/var/log/private/**"}
+
+	checks := []struct {
+		path, expected string
+	}{
+		{"/var/log/app/messages.log", ""},
+		{"/var/log/app/messages.log.1.gz", "*.gz"},
+		{"/var/log/app/debug-20170101.log", "debug-*.log"},
+		{"/var/log/private/nested/messages.log", "/var/log/private/**"},
+	}
+
+	for _, check := range checks {
+		rule, excluded := matchExclude(patterns, check.path)
+		if rule != check.expected || excluded != (check.expected != "") {
+			t.Errorf("Wrong exclude result for %s: %s != %s", check.path, rule, check.expected)
+		}
+	}
+}
+
+func TestGlobPathsRecursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "globtest")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{"a.log", "a.txt", "app/b.log", "app/nested/c.log"}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %s", err)
+		}
+	}
+
+	matches, err := globPaths(filepath.Join(dir, "**", "*.log"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "a.log"),
+		filepath.Join(dir, "app", "b.log"),
+		filepath.Join(dir, "app", "nested", "c.log"),
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("Wrong matches: %v != %v", matches, expected)
+	}
+
+	dirs, err := globDirs(filepath.Join(dir, "**", "*.log"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected = []string{dir, filepath.Join(dir, "app"), filepath.Join(dir, "app", "nested")}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Wrong directories: %v != %v", dirs, expected)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"os"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/harvester"
 	"github.com/driskell/log-courier/lc-lib/registrar"
 )
@@ -38,23 +39,36 @@ const (
 )
 
 type prospectorInfo struct {
-	file         string
-	identity     registrar.FileIdentity
-	lastSeen     uint32
-	status       int
-	running      bool
-	orphaned     int
-	finishOffset int64
-	harvester    *harvester.Harvester
-	err          error
+	file           string
+	identity       registrar.FileIdentity
+	lastSeen       uint32
+	status         int
+	running        bool
+	orphaned       int
+	finishOffset   int64
+	harvester      *harvester.Harvester
+	finishChan     <-chan *harvester.FinishStatus
+	fileConfig     *config.File
+	streamConfig   *config.Stream
+	queued         bool
+	queueOffset    int64
+	queuePartial   bool
+	deadStatus     *harvester.FinishStatus
+	inactiveStatus *harvester.FinishStatus
+	completeStatus *harvester.FinishStatus
+	completeOffset *int64
+	fingerprint    *registrar.Fingerprint
+	err            error
 }
 
 func newProspectorInfoFromFileState(file string, filestate *registrar.FileState) *prospectorInfo {
 	return &prospectorInfo{
-		file:         file,
-		identity:     filestate,
-		status:       statusResume,
-		finishOffset: filestate.Offset,
+		file:           file,
+		identity:       filestate,
+		status:         statusResume,
+		finishOffset:   filestate.Offset,
+		fingerprint:    filestate.Fingerprint,
+		completeOffset: filestate.CompleteOffset,
 	}
 }
 
@@ -83,7 +97,7 @@ func (pi *prospectorInfo) isRunning() bool {
 	}
 
 	select {
-	case status := <-pi.harvester.OnFinish():
+	case status := <-pi.finishChan:
 		pi.setHarvesterStopped(status)
 	default:
 	}
@@ -102,7 +116,7 @@ func (pi *prospectorInfo) wait() {
 	if !pi.running {
 		return
 	}
-	status := <-pi.harvester.OnFinish()
+	status := <-pi.finishChan
 	pi.setHarvesterStopped(status)
 }
 
@@ -115,6 +129,22 @@ func (pi *prospectorInfo) setHarvesterStopped(status *harvester.FinishStatus) {
 	// Resume harvesting from the last event offset, not the last read, to allow codec to read from the last event
 	// This ensures multiline codec populates correctly on resume
 	pi.finishOffset = status.LastEventOffset
+	if status.Dead && pi.streamConfig.DeadAction != "none" {
+		// Prospector will request the dead action from the registrar
+		pi.deadStatus = status
+	} else {
+		pi.deadStatus = nil
+	}
+	if status.Dead && isCompressed(pi.file, pi.fileConfig) {
+		// Prospector will record where the compressed file ends so it is not
+		// decompressed again after a restart
+		pi.completeStatus = status
+	}
+	if status.Inactive {
+		pi.inactiveStatus = status
+	} else {
+		pi.inactiveStatus = nil
+	}
 	if status.Error != nil {
 		pi.status = statusFailed
 		pi.err = status.Error
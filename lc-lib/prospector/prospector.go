@@ -20,8 +20,10 @@
 package prospector
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -50,6 +52,14 @@ type Prospector struct {
 	lastscan        time.Time
 	registrar       registrar.Registrator
 	registrarSpool  registrar.EventSpooler
+	method          string
+	watcher         watcher
+	watchEvents     <-chan []*watchEvent
+	execs           []*execRunner
+	queue           map[*prospectorInfo]*prospectorInfo
+	numRunning      int64
+	groupRunning    map[string]int64
+	finished        chan struct{}
 
 	output chan<- *core.EventDescriptor
 }
@@ -64,6 +74,8 @@ func NewProspector(pipeline *core.Pipeline, config *config.Config, fromBeginning
 		adminConfig:     config.Get("admin").(*admin.Config),
 		prospectorindex: make(map[string]*prospectorInfo),
 		prospectors:     make(map[*prospectorInfo]*prospectorInfo),
+		queue:           make(map[*prospectorInfo]*prospectorInfo),
+		finished:        make(chan struct{}, 1),
 		fromBeginning:   fromBeginning,
 		registrar:       registrarImp,
 		registrarSpool:  registrarImp.Connect(),
@@ -82,6 +94,8 @@ func NewProspector(pipeline *core.Pipeline, config *config.Config, fromBeginning
 }
 
 func (p *Prospector) init() (err error) {
+	p.groupRunning = make(map[string]int64)
+
 	var havePrevious bool
 	if havePrevious, err = p.registrar.LoadPrevious(p.loadCallback); err != nil {
 		return
@@ -97,6 +111,19 @@ func (p *Prospector) init() (err error) {
 		}
 	}
 
+	p.execs, _ = p.updateExecs()
+
+	p.method = "poll"
+	if p.config.General.ProspectMethod == "inotify" {
+		var watchErr error
+		if p.watcher, watchErr = newInotifyWatcher(); watchErr != nil {
+			log.Warning("Failed to initialise inotify, falling back to polling: %s", watchErr)
+		} else {
+			p.method = "inotify"
+			p.watchEvents = p.watcher.Events()
+		}
+	}
+
 	return
 }
 
@@ -111,6 +138,10 @@ func (p *Prospector) Run() {
 		p.Done()
 	}()
 
+	for _, runner := range p.execs {
+		runner.start()
+	}
+
 	for {
 		if p.runOnce() {
 			break
@@ -128,18 +159,129 @@ func (p *Prospector) Run() {
 	}
 	p.mutex.Unlock()
 
+	for _, runner := range p.execs {
+		runner.stop()
+	}
+
+	if p.watcher != nil {
+		p.watcher.Close()
+	}
+
 	// Disconnect from the registrar
 	p.registrarSpool.Close()
 
 	log.Info("Prospector exiting")
 }
 
+// updateExecs returns runners for the exec file groups in the configuration,
+// reusing the existing runners of commands that are still configured, along
+// with the runners of commands that are no longer configured
+func (p *Prospector) updateExecs() ([]*execRunner, []*execRunner) {
+	existing := make(map[string][]*execRunner)
+	for _, runner := range p.execs {
+		key := execKey(runner.fileConfig)
+		existing[key] = append(existing[key], runner)
+	}
+
+	var execs []*execRunner
+	for configKey, config := range p.config.Files {
+		if config.Type != "exec" {
+			continue
+		}
+
+		fileConfig := &p.config.Files[configKey]
+		key := execKey(fileConfig)
+		if runners := existing[key]; len(runners) != 0 {
+			runners[0].reconfigure(p.config, fileConfig)
+			execs = append(execs, runners[0])
+			existing[key] = runners[1:]
+			continue
+		}
+
+		execs = append(execs, newExecRunner(p.config, fileConfig, p.output))
+	}
+
+	var removed []*execRunner
+	for _, runners := range existing {
+		removed = append(removed, runners...)
+	}
+
+	return execs, removed
+}
+
+// reloadExecs starts and stops commands following a configuration reload.
+// Commands that are still configured continue running
+func (p *Prospector) reloadExecs() {
+	execs, removed := p.updateExecs()
+
+	for _, runner := range removed {
+		log.Info("Stopping command that is no longer configured: %s", runner.command)
+		runner.stop()
+	}
+
+	started := make(map[*execRunner]bool, len(p.execs))
+	for _, runner := range p.execs {
+		started[runner] = true
+	}
+	for _, runner := range execs {
+		if !started[runner] {
+			runner.start()
+		}
+	}
+
+	p.mutex.Lock()
+	p.execs = execs
+	p.mutex.Unlock()
+}
+
 // runOnce handles a single prospector iteration
 // Returns true if shutdown is necessary
 func (p *Prospector) runOnce() bool {
+	p.scanAll()
+
+	// Defer next scan for a bit
+	now := time.Now()
+	scanDeadline := now.Add(p.config.General.ProspectInterval)
+
+DelayLoop:
+	for {
+		select {
+		case <-time.After(scanDeadline.Sub(now)):
+			break DelayLoop
+		case <-p.OnShutdown():
+			return true
+		case config := <-p.OnConfig():
+			p.config = config
+			p.reloadQueue()
+			p.reloadExecs()
+		case <-p.finished:
+			// A harvester finished so a queued file may be able to start
+			p.mutex.Lock()
+			p.startQueued()
+			p.mutex.Unlock()
+		case events := <-p.watchEvents:
+			p.processEvents(events)
+		}
+
+		now = time.Now()
+		if now.After(scanDeadline) {
+			break
+		}
+	}
+
+	return false
+}
+
+// scanAll scans all configured paths, updates the set of watched directories,
+// and cleans up files which are no longer found
+func (p *Prospector) scanAll() {
 	newlastscan := time.Now()
 	p.iteration++ // Overflow is allowed
 
+	p.mutex.Lock()
+	p.countHarvesters()
+	p.mutex.Unlock()
+
 	for configKey, config := range p.config.Files {
 		for _, path := range config.Paths {
 			p.scan(path, &p.config.Files[configKey])
@@ -153,8 +295,24 @@ func (p *Prospector) runOnce() bool {
 	// Clean up the prospector collections
 	p.mutex.Lock()
 	for _, info := range p.prospectors {
+		if !info.isRunning() {
+			if info.completeStatus != nil {
+				offset := info.completeStatus.LastEventOffset
+				info.completeOffset = &offset
+				p.registrarSpool.Add(registrar.NewCompleteEvent(info, offset))
+				info.completeStatus = nil
+			}
+
+			if info.deadStatus != nil {
+				// Registrar performs the dead action once the final offset is acknowledged
+				p.registrarSpool.Add(registrar.NewDeadEvent(info, info.deadStatus.LastShippedOffset, info.deadStatus.LastStat, info.streamConfig))
+				info.deadStatus = nil
+			}
+		}
+
 		if info.orphaned >= orphanedMaybe {
 			if !info.isRunning() {
+				p.dequeueHarvester(info)
 				delete(p.prospectors, info)
 			}
 		} else {
@@ -169,6 +327,9 @@ func (p *Prospector) runOnce() bool {
 			p.registrarSpool.Add(registrar.NewDeletedEvent(info))
 		}
 	}
+
+	// Harvesters that finished have made room for queued ones
+	p.startQueued()
 	p.mutex.Unlock()
 
 	// Flush the accumulated registrar events
@@ -176,34 +337,125 @@ func (p *Prospector) runOnce() bool {
 
 	p.lastscan = newlastscan
 
-	// Defer next scan for a bit
-	now := time.Now()
-	scanDeadline := now.Add(p.config.General.ProspectInterval)
+	if p.watcher != nil {
+		p.watcher.Watch(p.watchDirs())
+	}
+}
 
-DelayLoop:
-	for {
-		select {
-		case <-time.After(scanDeadline.Sub(now)):
-			break DelayLoop
-		case <-p.OnShutdown():
-			return true
-		case config := <-p.OnConfig():
-			p.config = config
+// watchDirs returns the directories that contain files matching the
+// configured paths
+func (p *Prospector) watchDirs() []string {
+	seen := make(map[string]bool)
+	dirs := make([]string, 0)
+
+	for _, config := range p.config.Files {
+		for _, path := range config.Paths {
+			matches, err := globDirs(path)
+			if err != nil {
+				continue
+			}
+
+			for _, dir := range matches {
+				if seen[dir] {
+					continue
+				}
+				seen[dir] = true
+
+				if info, err := os.Stat(dir); err == nil && info.IsDir() {
+					dirs = append(dirs, dir)
+				}
+			}
 		}
+	}
 
-		now = time.Now()
-		if now.After(scanDeadline) {
-			break
+	return dirs
+}
+
+// processEvents handles a batch of watcher events, processing the affected
+// files immediately. Only lost events trigger a full scan
+func (p *Prospector) processEvents(events []*watchEvent) {
+	p.mutex.Lock()
+	// Each batch is a new iteration so that files seen by the last scan are
+	// not mistaken for duplicates
+	p.iteration++
+	p.countHarvesters()
+	p.mutex.Unlock()
+
+	newDirs := false
+	for _, event := range events {
+		if event.path == "" {
+			// Events were lost so we don't know what changed
+			p.scanAll()
+			return
+		}
+
+		if event.removed {
+			p.processRemoved(event.path)
+			continue
+		}
+
+		// New directories may need watching if they match a recursive glob, and
+		// may already contain files if they were moved here
+		if info, err := os.Stat(event.path); err == nil && info.IsDir() {
+			newDirs = true
+			globWalk(event.path, func(path string, info os.FileInfo) {
+				if !info.IsDir() {
+					p.processPath(path)
+				}
+			})
+			continue
 		}
+
+		p.processPath(event.path)
 	}
 
-	return false
+	if newDirs && p.watcher != nil {
+		p.watcher.Watch(p.watchDirs())
+	}
+
+	p.registrarSpool.Send()
+}
+
+// processPath processes a file for every file group with a path that matches
+// it
+func (p *Prospector) processPath(file string) {
+	for configKey, config := range p.config.Files {
+		for _, path := range config.Paths {
+			if matchPath(path, file) {
+				p.processFile(file, &p.config.Files[configKey])
+			}
+		}
+	}
+}
+
+// processRemoved handles the removal of a path, or of a directory containing
+// files, by orphaning the files that no longer exist. Registrar state is kept
+// until the next scan in case the file was renamed and is found again, and
+// harvesters continue until they finish reading the file
+func (p *Prospector) processRemoved(removed string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	prefix := removed + string(filepath.Separator)
+	for file, info := range p.prospectorindex {
+		if file != removed && !strings.HasPrefix(file, prefix) {
+			continue
+		}
+
+		if _, err := os.Lstat(file); !os.IsNotExist(err) {
+			// Something else was put in its place
+			continue
+		}
+
+		delete(p.prospectorindex, file)
+		info.orphaned = orphanedMaybe
+	}
 }
 
 // scan crawls a path for file movements
 func (p *Prospector) scan(path string, config *config.File) {
 	// Evaluate the path as a wildcards/shell glob
-	matches, err := filepath.Glob(path)
+	matches, err := globPaths(path)
 	if err != nil {
 		log.Error("glob(%s) failed: %v", path, err)
 		return
@@ -230,8 +482,11 @@ func (p *Prospector) processFile(file string, config *config.File) {
 	// Stat the file, following any symlinks
 	// TODO: Low priority. Trigger loadFileId here for Windows instead of
 	//       waiting for Harvester or Registrar to do it
-	fileinfo, err := os.Stat(file)
-	if err == nil {
+	var fileinfo os.FileInfo
+	var err error
+	if rule, excluded := matchExclude(config.ExcludePaths, file); excluded {
+		err = newProspectorSkipError(fmt.Sprintf("Excluded by %s", rule))
+	} else if fileinfo, err = os.Stat(file); err == nil {
 		if fileinfo.IsDir() {
 			err = newProspectorSkipError("Directory")
 		}
@@ -275,7 +530,7 @@ func (p *Prospector) processFile(file string, config *config.File) {
 	// - the file's inode or device changed
 	if !isKnown {
 		// Is this a rename/move?
-		if previous, previousinfo := p.lookupFileIds(file, fileinfo); previous != "" {
+		if previous, previousinfo := p.lookupFileIds(file, fileinfo, config); previous != "" {
 			// Symlinks could mean we see the same file twice - skip if we have
 			if previousinfo == nil {
 				p.flagDuplicateError(file, info)
@@ -294,7 +549,11 @@ func (p *Prospector) processFile(file string, config *config.File) {
 
 			// Check for dead time, but only if the file modification time is before the last scan started
 			// This ensures we don't skip genuine creations with dead times less than 10s
-			if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > config.DeadTime {
+			// Compressed files are instead checked against the file they were
+			// created from, so that only what was not shipped from it is read
+			if isCompressed(file, config) {
+				p.startCompressed(info, file, fileinfo, config)
+			} else if fileinfo.ModTime().Before(p.lastscan) && time.Since(fileinfo.ModTime()) > config.DeadTime {
 				// Old file, skip it, but push offset of file size so we start from the end if this file changes and needs picking up
 				log.Info("Skipping file (older than dead time of %v): %s", config.DeadTime, file)
 
@@ -311,11 +570,18 @@ func (p *Prospector) processFile(file string, config *config.File) {
 		// Store the new entry
 		p.prospectors[info] = info
 	} else {
-		if !info.identity.SameAs(fileinfo) {
+		same := p.sameFile(info, file, fileinfo, config)
+		if !same && config.Identity == "fingerprint" && info.identity.SameInode(fileinfo) && info.isRunning() {
+			// The file was rewritten in place, such as by copytruncate, and the
+			// running harvester will restart from the beginning once it sees the
+			// truncation, so starting another would ship the data twice
+			log.Info("File was rewritten, the running harvester will continue: %s", file)
+			info.fingerprint = nil
+		} else if !same {
 			// Keep the old file in case we find it again shortly
 			info.orphaned = orphanedMaybe
 
-			if previous, previousinfo := p.lookupFileIds(file, fileinfo); previous != "" {
+			if previous, previousinfo := p.lookupFileIds(file, fileinfo, config); previous != "" {
 				// Symlinks could mean we see the same file twice - skip if we have
 				if previousinfo == nil {
 					p.flagDuplicateError(file, nil)
@@ -344,15 +610,25 @@ func (p *Prospector) processFile(file string, config *config.File) {
 		}
 	}
 
-	// Resume stopped harvesters
-	resume := !info.isRunning()
+	// Resume stopped harvesters, unless already waiting for a slot
+	resume := !info.isRunning() && !info.queued
 	if resume {
 		if info.status == statusResume {
-			if info.finishOffset == fileinfo.Size() && time.Since(fileinfo.ModTime()) > config.DeadTime {
+			if info.completeOffset != nil && info.finishOffset >= *info.completeOffset {
+				// Compressed file that was already read to the end
+				log.Info("Skipping compressed file (already complete): %s", file)
+				info.status = statusOk
+				resume = false
+			} else if !isCompressed(file, config) && info.finishOffset == fileinfo.Size() && time.Since(fileinfo.ModTime()) > config.DeadTime {
 				// Old file with an unchanged offset, skip it
 				log.Info("Skipping file (older than dead time of %v): %s", config.DeadTime, file)
 				info.status = statusOk
 				resume = false
+
+				// The dead action may not have been performed before we stopped
+				if config.DeadAction != "none" {
+					p.registrarSpool.Add(registrar.NewDeadEvent(info, info.finishOffset, fileinfo, &config.Stream))
+				}
 			} else {
 				// This is a filestate that was saved, resume the harvester
 				log.Info("Resuming harvester on a previously harvested file: %s", file)
@@ -360,18 +636,29 @@ func (p *Prospector) processFile(file string, config *config.File) {
 		} else if info.status == statusFailed {
 			// Last attempt we failed to start, try again
 			log.Info("Attempting to restart failed harvester: %s", file)
-		} else if info.identity.Stat().ModTime() != fileinfo.ModTime() {
+		} else if info.identity.Stat().ModTime() != fileinfo.ModTime() || info.identity.Stat().Size() != fileinfo.Size() {
 			// Resume harvesting of an old file we've stopped harvesting from
 			log.Info("Resuming harvester on an old file that was just modified: %s", file)
 		} else {
 			resume = false
+
+			// A file closed by close inactive becomes dead once it has remained
+			// unchanged for dead time
+			if info.inactiveStatus != nil && time.Since(fileinfo.ModTime()) > config.DeadTime {
+				if info.streamConfig.DeadAction != "none" {
+					info.deadStatus = info.inactiveStatus
+				}
+				info.inactiveStatus = nil
+			}
 		}
 	}
 
+	p.updateFingerprint(info, file, fileinfo, config)
+
 	info.update(fileinfo, p.iteration)
 
 	if resume {
-		p.startHarvesterWithOffset(info, config, info.finishOffset)
+		p.startHarvesterWithOffset(info, config, info.finishOffset, false)
 	}
 
 	p.prospectorindex[file] = info
@@ -400,7 +687,9 @@ func (p *Prospector) flagDuplicateError(file string, info *prospectorInfo) {
 func (p *Prospector) startHarvester(info *prospectorInfo, fileconfig *config.File) {
 	var offset int64
 
-	if p.fromBeginning {
+	// The end offset of a compressed file is unknown without decompressing it,
+	// so these are always started from the beginning
+	if p.fromBeginning || isCompressed(info.file, fileconfig) {
 		offset = 0
 	} else {
 		offset = info.identity.Stat().Size()
@@ -409,22 +698,222 @@ func (p *Prospector) startHarvester(info *prospectorInfo, fileconfig *config.Fil
 	// Send a new file event to allow registrar to begin persisting for this harvester
 	p.registrarSpool.Add(registrar.NewDiscoverEvent(info, info.file, offset, info.identity.Stat()))
 
-	p.startHarvesterWithOffset(info, fileconfig, offset)
+	// Starting from the end of an existing file may be part way through an event
+	p.startHarvesterWithOffset(info, fileconfig, offset, offset != 0)
 }
 
 // startHarvesterWithOffset starts a new harvester against a file starting at
-// the given offset
-func (p *Prospector) startHarvesterWithOffset(info *prospectorInfo, fileconfig *config.File, offset int64) {
+// the given offset, or queues it if the maximum number of harvesters are
+// already running. Partial is true if the offset is not known to be the start
+// of an event
+func (p *Prospector) startHarvesterWithOffset(info *prospectorInfo, fileconfig *config.File, offset int64, partial bool) {
+	if !p.slotAvailable(fileconfig) {
+		p.queueHarvester(info, fileconfig, offset, partial)
+		return
+	}
+
+	p.runHarvester(info, fileconfig, offset, partial)
+}
+
+// runHarvester starts a new harvester against a file starting at the given
+// offset
+func (p *Prospector) runHarvester(info *prospectorInfo, fileconfig *config.File, offset int64, partial bool) {
 	// TODO - hook in a shutdown channel
-	info.harvester = harvester.NewHarvester(info, p.config, &fileconfig.Stream, offset)
+	info.harvester = harvester.NewHarvester(info, p.config, &fileconfig.Stream, offset, pathFields(info.file, fileconfig))
+	if partial {
+		info.harvester.StartPartial()
+	}
+	info.fileConfig = fileconfig
+	info.streamConfig = &fileconfig.Stream
+	info.deadStatus = nil
+	info.inactiveStatus = nil
 	info.running = true
 	info.status = statusOk
 	info.harvester.Start(p.output)
+	info.finishChan = p.watchHarvester(info.harvester)
+
+	p.numRunning++
+	p.groupRunning[groupKey(fileconfig)]++
+}
+
+// pathFields returns the fields captured from the path of a file by the path
+// fields pattern of its file group, or nil if there are none
+func pathFields(file string, fileconfig *config.File) map[string]interface{} {
+	if fileconfig.PathFieldsPattern == nil {
+		return nil
+	}
+
+	match := fileconfig.PathFieldsPattern.FindStringSubmatch(file)
+	if match == nil {
+		log.Warning("Path fields pattern did not match file, no path fields will be added: %s", file)
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+	for i, name := range fileconfig.PathFieldsPattern.SubexpNames() {
+		if name != "" && match[i] != "" {
+			fields[name] = match[i]
+		}
+	}
+
+	return fields
+}
+
+// isCompressed returns true if the given file will be decompressed by its
+// harvester
+func isCompressed(file string, fileconfig *config.File) bool {
+	return harvester.CompressionForPath(file, fileconfig.Compression) != harvester.CompressionNone
+}
+
+// sameFile checks whether a file is the one the prospector info was created
+// for. With fingerprint identity the file must also start with the same data,
+// and the device is ignored so that remounting a network filesystem does not
+// cause its files to be seen as new
+func (p *Prospector) sameFile(info *prospectorInfo, file string, fileinfo os.FileInfo, config *config.File) bool {
+	if config.Identity != "fingerprint" || info.fingerprint == nil {
+		return info.identity.SameAs(fileinfo)
+	}
+
+	if !info.identity.SameInode(fileinfo) {
+		return false
+	}
+
+	// If nothing changed since we last looked, the data can't have either
+	if previous := info.identity.Stat(); previous != nil && os.SameFile(previous, fileinfo) && previous.Size() == fileinfo.Size() && previous.ModTime().Equal(fileinfo.ModTime()) {
+		return true
+	}
+
+	matched, fingerprint, err := info.fingerprint.Check(file, config.FingerprintSize)
+	if err != nil {
+		log.Warning("Failed to check fingerprint of %s, comparing inode and device only: %s", file, err)
+		return info.identity.SameAs(fileinfo)
+	}
+
+	if !matched {
+		log.Info("Fingerprint of %s does not match the data previously seen", file)
+		return false
+	}
+
+	if fingerprint != info.fingerprint {
+		info.fingerprint = fingerprint
+		p.registrarSpool.Add(registrar.NewFingerprintEvent(info, fingerprint))
+	}
+
+	return true
+}
+
+// startCompressed starts harvesting a compressed file that was not seen
+// before. If it is a compressed copy of a known file, such as one rotated by
+// logrotate, harvesting starts where that file was harvested up to, and if
+// that file is still being harvested the compressed file is skipped, as its
+// harvester will read the rest of it
+func (p *Prospector) startCompressed(info *prospectorInfo, file string, fileinfo os.FileInfo, config *config.File) {
+	var reason string
+	if !p.fromBeginning {
+		reason = "starting from the end of existing files"
+	} else if original := p.lookupOriginal(file, config); original == nil {
+		log.Info("Launching harvester on new compressed file: %s", file)
+		p.startHarvester(info, config)
+		return
+	} else if original.isRunning() {
+		reason = fmt.Sprintf("%s is still being harvested", original.file)
+	} else {
+		log.Info("Launching harvester on compressed copy of %s at offset %d: %s", original.file, original.finishOffset, file)
+		p.registrarSpool.Add(registrar.NewDiscoverEvent(info, file, original.finishOffset, fileinfo))
+		p.startHarvesterWithOffset(info, config, original.finishOffset, false)
+		return
+	}
+
+	log.Info("Skipping compressed file (%s): %s", reason, file)
+
+	// Nothing more will be read unless the file is replaced
+	var offset int64
+	info.completeOffset = &offset
+	p.registrarSpool.Add(registrar.NewDiscoverEvent(info, file, 0, fileinfo))
+	p.registrarSpool.Add(registrar.NewCompleteEvent(info, 0))
+}
+
+// lookupOriginal finds the known file that a compressed file is a copy of, by
+// comparing the start of its uncompressed data with the fingerprints of known
+// files. If several match, the one with the longest fingerprint is used, and
+// then the one harvested the least, so that nothing is skipped
+func (p *Prospector) lookupOriginal(file string, config *config.File) *prospectorInfo {
+	data, err := harvester.ReadDecompressed(file, harvester.CompressionForPath(file, config.Compression), config.FingerprintSize)
+	if err != nil {
+		log.Warning("Failed to read %s to find the file it was compressed from: %s", file, err)
+		return nil
+	}
+
+	var original *prospectorInfo
+	for _, ki := range p.prospectors {
+		if ki.fingerprint == nil || !ki.fingerprint.Matches(data) {
+			continue
+		}
+
+		if ki.isRunning() {
+			return ki
+		}
+
+		if original == nil || ki.fingerprint.Length > original.fingerprint.Length || (ki.fingerprint.Length == original.fingerprint.Length && ki.finishOffset < original.finishOffset) {
+			original = ki
+		}
+	}
+
+	return original
+}
+
+// updateFingerprint calculates the fingerprint of a file that does not have
+// one yet, either because it is new or because it was empty. Uncompressed
+// files are fingerprinted with inode identity too, so that compressed copies
+// of them can be recognised, and as the fingerprint is not otherwise checked
+// it is checked again if the file may have been rewritten
+func (p *Prospector) updateFingerprint(info *prospectorInfo, file string, fileinfo os.FileInfo, config *config.File) {
+	if fileinfo.Size() == 0 {
+		return
+	}
+
+	if config.Identity == "fingerprint" {
+		if info.fingerprint != nil {
+			return
+		}
+	} else if isCompressed(file, config) {
+		return
+	} else if info.fingerprint != nil {
+		previous := info.identity.Stat()
+		if previous != nil && fileinfo.Size() >= previous.Size() && (info.fingerprint.Length >= config.FingerprintSize || previous.ModTime().Equal(fileinfo.ModTime())) {
+			return
+		}
+
+		matched, fingerprint, err := info.fingerprint.Check(file, config.FingerprintSize)
+		if err != nil {
+			log.Warning("Failed to check fingerprint of %s: %s", file, err)
+			return
+		}
+
+		if matched {
+			if fingerprint != info.fingerprint {
+				info.fingerprint = fingerprint
+				p.registrarSpool.Add(registrar.NewFingerprintEvent(info, fingerprint))
+			}
+			return
+		}
+	}
+
+	fingerprint, err := registrar.CalculateFingerprint(file, config.FingerprintSize)
+	if err != nil {
+		log.Warning("Failed to calculate fingerprint of %s: %s", file, err)
+		return
+	}
+
+	if fingerprint != nil {
+		info.fingerprint = fingerprint
+		p.registrarSpool.Add(registrar.NewFingerprintEvent(info, fingerprint))
+	}
 }
 
 // lookupFileIds checks a file's filesystem identifiers against all other known
 // files so we can handle file movements and renames
-func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *prospectorInfo) {
+func (p *Prospector) lookupFileIds(file string, info os.FileInfo, config *config.File) (string, *prospectorInfo) {
 	for _, ki := range p.prospectors {
 		if ki.status == statusInvalid {
 			// Don't consider error placeholders
@@ -434,7 +923,7 @@ func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *pros
 			// We already know the prospector info for this file doesn't match, so don't check again
 			continue
 		}
-		if ki.identity.SameAs(info) {
+		if p.sameFile(ki, file, info, config) {
 			// Already seen?
 			if ki.lastSeen == p.iteration {
 				return ki.file, nil
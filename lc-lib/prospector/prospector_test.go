@@ -0,0 +1,448 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// testSpool processes registrar events immediately into its own state
+type testSpool struct {
+	state map[core.Stream]*registrar.FileState
+}
+
+func (s *testSpool) Close() {
+}
+
+func (s *testSpool) Add(event registrar.EventProcessor) {
+	event.Process(s.state)
+}
+
+func (s *testSpool) Send() {
+}
+
+// newTestProspector creates a prospector for a single file group, without a
+// registrar or spooler
+func newTestProspector(t *testing.T, configure func(*config.File)) (*Prospector, *testSpool) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+
+	fileconfig := config.File{}
+	fileconfig.InitDefaults()
+	fileconfig.Stream.InitDefaults()
+	fileconfig.Codecs = []config.CodecStub{{Name: "plain"}}
+	if configure != nil {
+		configure(&fileconfig)
+	}
+	if err := cfg.InitCodecs("/files[0]/codecs/", fileconfig.Codecs); err != nil {
+		t.Fatalf("Failed to initialise codecs: %s", err)
+	}
+	cfg.Files = []config.File{fileconfig}
+
+	spool := &testSpool{state: make(map[core.Stream]*registrar.FileState)}
+	return &Prospector{
+		config:          cfg,
+		prospectorindex: make(map[string]*prospectorInfo),
+		prospectors:     make(map[*prospectorInfo]*prospectorInfo),
+		queue:           make(map[*prospectorInfo]*prospectorInfo),
+		groupRunning:    make(map[string]int64),
+		finished:        make(chan struct{}, 1),
+		fromBeginning:   true,
+		registrarSpool:  spool,
+		output:          make(chan *core.EventDescriptor, 100),
+	}, spool
+}
+
+// stopHarvesters stops all harvesters started by a test prospector
+func stopHarvesters(p *Prospector) {
+	for _, info := range p.prospectors {
+		info.stop()
+	}
+	for _, info := range p.prospectors {
+		info.wait()
+	}
+}
+
+// writeGzip writes a gzip compressed file with the given contents and
+// modification time
+func writeGzip(t *testing.T, path string, data string, modTime time.Time) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %s", path, err)
+	}
+	writer := gzip.NewWriter(file)
+	writer.Write([]byte(data))
+	writer.Close()
+	file.Close()
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set modification time of %s: %s", path, err)
+	}
+}
+
+func TestPathFields(t *testing.T) {
+	fileconfig := &config.File{
+		PathFieldsPattern: regexp.MustCompile(`/var/log/containers/(?P<pod>[^_]+)_(?P<namespace>[^_]+)_(?P<container>.+)-(?P<container_id>[0-9a-f]{64})\.log$`),
+	}
+
+	fields := pathFields("/var/log/containers/web-6d4cf56db6-x7k2p_default_nginx-"+
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef.log", fileconfig)
+	expected := map[string]interface{}{
+		"pod":          "web-6d4cf56db6-x7k2p",
+		"namespace":    "default",
+		"container":    "nginx",
+		"container_id": "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Wrong fields returned: %v", fields)
+	}
+
+	if fields := pathFields("/var/log/messages", fileconfig); fields != nil {
+		t.Errorf("Fields returned for path that does not match: %v", fields)
+	}
+
+	fileconfig = &config.File{
+		PathFieldsPattern: regexp.MustCompile(`^/data/(?P<tenant>[^/]+)/(?P<app>[^/]+)/(?:(?P<archive>archive)/)?`),
+	}
+
+	fields = pathFields("/data/acme/billing/app.log", fileconfig)
+	expected = map[string]interface{}{
+		"tenant": "acme",
+		"app":    "billing",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Wrong fields returned: %v", fields)
+	}
+
+	if fields := pathFields("/data/acme/billing/app.log", &config.File{}); fields != nil {
+		t.Errorf("Fields returned without a pattern: %v", fields)
+	}
+}
+
+func TestCompressedAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, spool := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "*")}
+		fileconfig.Compression = "auto"
+	})
+	defer stopHarvesters(p)
+	output := make(chan *core.EventDescriptor, 10)
+	p.output = output
+
+	// The previous run shipped the first line of app.log.1, which was then
+	// compressed and removed by logrotate while we were not running
+	originalPath := filepath.Join(dir, "app.log.1")
+	if err := ioutil.WriteFile(originalPath, []byte("first line\nsecond line\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", originalPath, err)
+	}
+	fileinfo, err := os.Stat(originalPath)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %s", originalPath, err)
+	}
+	fingerprint, err := registrar.CalculateFingerprint(originalPath, p.config.Files[0].FingerprintSize)
+	if err != nil {
+		t.Fatalf("Failed to calculate fingerprint of %s: %s", originalPath, err)
+	}
+	state := &registrar.FileState{Source: &originalPath, Offset: 11, Fingerprint: fingerprint}
+	state.PopulateFileIds(fileinfo)
+	stream, _ := p.loadCallback(originalPath, state)
+	p.prospectors[stream.(*prospectorInfo)] = stream.(*prospectorInfo)
+
+	path := filepath.Join(dir, "app.log.1.gz")
+	writeGzip(t, path, "first line\nsecond line\n", time.Now().Add(-time.Hour))
+	otherPath := filepath.Join(dir, "other.log.1.gz")
+	writeGzip(t, otherPath, "other line\n", time.Now().Add(-time.Hour))
+	os.Remove(originalPath)
+	p.processFile(path, &p.config.Files[0])
+
+	info := p.prospectorindex[path]
+	if info == nil || !info.running {
+		t.Fatal("Harvester was not started on compressed file")
+	}
+	if state := spool.state[info]; state == nil || state.Offset != 11 {
+		t.Errorf("Compressed file was not started where its original finished: %v", state)
+	}
+
+	select {
+	case desc := <-output:
+		if desc.Offset != 23 {
+			t.Errorf("Wrong event offset: %d", desc.Offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+
+	// A compressed file that is not a copy of a known file is read in full
+	p.processFile(otherPath, &p.config.Files[0])
+
+	info = p.prospectorindex[otherPath]
+	if info == nil || !info.running {
+		t.Fatal("Harvester was not started on unknown compressed file")
+	}
+	if state := spool.state[info]; state == nil || state.Offset != 0 {
+		t.Errorf("Unknown compressed file was not started from the beginning: %v", state)
+	}
+}
+
+func TestCompressedOriginalRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, spool := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "*")}
+		fileconfig.Compression = "auto"
+	})
+	defer stopHarvesters(p)
+
+	originalPath := filepath.Join(dir, "app.log.1")
+	if err := ioutil.WriteFile(originalPath, []byte("first line\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", originalPath, err)
+	}
+	p.processFile(originalPath, &p.config.Files[0])
+	if original := p.prospectorindex[originalPath]; original == nil || !original.isRunning() || original.fingerprint == nil {
+		t.Fatal("Harvester was not started with a fingerprint on original file")
+	}
+
+	// The harvester of the original file reads the rest of it
+	path := filepath.Join(dir, "app.log.1.gz")
+	writeGzip(t, path, "first line\n", time.Now())
+	p.processFile(path, &p.config.Files[0])
+
+	info := p.prospectorindex[path]
+	if info == nil || info.running {
+		t.Error("Harvester was started on compressed copy of running file")
+	}
+	if state := spool.state[info]; state == nil || state.CompleteOffset == nil || *state.CompleteOffset != 0 {
+		t.Errorf("Skipped compressed file was not recorded as complete: %v", state)
+	}
+}
+
+func TestCompressedResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log.1.gz")
+	writeGzip(t, path, "first line\nsecond line\n", time.Now().Add(-time.Hour))
+	fileinfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %s", path, err)
+	}
+
+	completeOffset := int64(23)
+	for _, check := range []struct {
+		offset  int64
+		running bool
+	}{
+		{23, false},
+		{11, true},
+	} {
+		p, _ := newTestProspector(t, func(fileconfig *config.File) {
+			fileconfig.Compression = "auto"
+		})
+
+		// Resume from a previous run that read the whole file
+		state := &registrar.FileState{Source: &path, Offset: check.offset, CompleteOffset: &completeOffset}
+		state.PopulateFileIds(fileinfo)
+		stream, _ := p.loadCallback(path, state)
+		p.prospectors[stream.(*prospectorInfo)] = stream.(*prospectorInfo)
+
+		p.processFile(path, &p.config.Files[0])
+
+		if running := p.prospectorindex[path].running; running != check.running {
+			t.Errorf("Wrong harvester state resuming at offset %d: running = %t", check.offset, running)
+		}
+
+		stopHarvesters(p)
+	}
+}
+
+func TestDeadActionOnResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, partial := range []bool{false, true} {
+		path := filepath.Join(dir, "app.log")
+		if err := ioutil.WriteFile(path, []byte("line\n"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %s", path, err)
+		}
+		modTime := time.Now().Add(-2 * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+		fileinfo, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %s", path, err)
+		}
+
+		p, spool := newTestProspector(t, func(fileconfig *config.File) {
+			fileconfig.DeadAction = "delete"
+			fileconfig.DeadTime = time.Hour
+		})
+
+		// Resume from a previous run that stopped before performing the dead action
+		state := &registrar.FileState{Source: &path, Offset: 5, Partial: partial}
+		state.PopulateFileIds(fileinfo)
+		stream, _ := p.loadCallback(path, state)
+		p.prospectors[stream.(*prospectorInfo)] = stream.(*prospectorInfo)
+		spool.state[stream] = state
+
+		p.processFile(path, &p.config.Files[0])
+
+		_, err = os.Stat(path)
+		if partial && err != nil {
+			t.Errorf("Partially harvested file was deleted on resume: %s", err)
+		} else if !partial && !os.IsNotExist(err) {
+			t.Errorf("Dead file was not deleted on resume: %v", err)
+		}
+	}
+}
+
+func TestProcessEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, spool := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "**", "*.log")}
+	})
+	defer stopHarvesters(p)
+
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("line\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+	p.processEvents([]*watchEvent{{path: path}})
+
+	info := p.prospectorindex[path]
+	if info == nil || !info.running {
+		t.Fatalf("Harvester was not started on created file")
+	}
+
+	// A rename is seen as a removal followed by a creation
+	renamed := filepath.Join(dir, "sub", "renamed.log")
+	os.Mkdir(filepath.Join(dir, "sub"), 0700)
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatalf("Failed to rename %s: %s", path, err)
+	}
+	p.processEvents([]*watchEvent{{path: path, removed: true}, {path: filepath.Join(dir, "sub")}})
+
+	if p.prospectorindex[renamed] != info {
+		t.Errorf("Rename into new directory was not detected")
+	}
+	if *spool.state[info].Source != renamed {
+		t.Errorf("Registrar state was not renamed: %s", *spool.state[info].Source)
+	}
+
+	// Removals orphan the file without a full scan
+	os.Remove(renamed)
+	p.processEvents([]*watchEvent{{path: renamed, removed: true}})
+
+	if _, ok := p.prospectorindex[renamed]; ok {
+		t.Error("Removed file is still indexed")
+	}
+	if info.orphaned != orphanedMaybe {
+		t.Errorf("Removed file was not orphaned: %d", info.orphaned)
+	}
+	if !p.lastscan.IsZero() {
+		t.Errorf("Events caused a full scan")
+	}
+}
+
+func TestFileRewrittenInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, spool := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "*.log")}
+		fileconfig.Identity = "fingerprint"
+	})
+	defer stopHarvesters(p)
+
+	path := filepath.Join(dir, "app.log")
+	rewrite := func(data string) {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %s", path, err)
+		}
+		file.Write([]byte(data))
+		file.Close()
+	}
+
+	rewrite("line\n")
+	p.processFile(path, &p.config.Files[0])
+
+	info := p.prospectorindex[path]
+	if info == nil || !info.running || info.fingerprint == nil {
+		t.Fatalf("Harvester was not started with a fingerprint")
+	}
+
+	// Truncating and rewriting the same inode leaves the running harvester to
+	// handle the truncation
+	rewrite("rewritten\n")
+	p.processFile(path, &p.config.Files[0])
+
+	if p.prospectorindex[path] != info || len(p.prospectors) != 1 {
+		t.Fatalf("Second harvester was started on rewritten file")
+	}
+	if !info.isRunning() {
+		t.Errorf("Harvester was stopped")
+	}
+	if info.fingerprint == nil || info.fingerprint.Length != 10 || spool.state[info].Fingerprint != info.fingerprint {
+		t.Errorf("Fingerprint was not updated: %v", info.fingerprint)
+	}
+
+	// Once the harvester has stopped, a rewritten file is treated as new
+	info.stop()
+	info.wait()
+	rewrite("again\n")
+	p.processFile(path, &p.config.Files[0])
+
+	if p.prospectorindex[path] == info || len(p.prospectors) != 2 {
+		t.Errorf("Rewritten file was not treated as new once stopped")
+	}
+	if !p.prospectorindex[path].isRunning() {
+		t.Errorf("Harvester was not started on rewritten file")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/harvester"
+)
+
+// harvesterQueue sorts queued files so that the file with the oldest
+// modification time is started first
+type harvesterQueue []*prospectorInfo
+
+func (q harvesterQueue) Len() int {
+	return len(q)
+}
+
+func (q harvesterQueue) Less(i, j int) bool {
+	return q[i].modTime().Before(q[j].modTime())
+}
+
+func (q harvesterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+// groupKey returns the key used to count the harvesters of a file group. File
+// groups are identified by their paths so that harvesters started before a
+// configuration reload still count towards the same group afterwards
+func groupKey(fileconfig *config.File) string {
+	return strings.Join(fileconfig.Paths, "\x00")
+}
+
+// countHarvesters counts the running harvesters, in total and for each file
+// group. Must be called with the mutex held
+func (p *Prospector) countHarvesters() {
+	p.numRunning = 0
+	p.groupRunning = make(map[string]int64)
+
+	for _, info := range p.prospectors {
+		if info.isRunning() {
+			p.numRunning++
+			p.groupRunning[groupKey(info.fileConfig)]++
+		}
+	}
+}
+
+// globalSlotAvailable returns true if the general maximum number of
+// harvesters has not been reached
+func (p *Prospector) globalSlotAvailable() bool {
+	return p.config.General.MaxHarvesters == 0 || p.numRunning < p.config.General.MaxHarvesters
+}
+
+// slotAvailable returns true if another harvester can be started for the
+// given file group without exceeding the general or file group maximum
+func (p *Prospector) slotAvailable(fileconfig *config.File) bool {
+	if !p.globalSlotAvailable() {
+		return false
+	}
+
+	return fileconfig.MaxHarvesters == 0 || p.groupRunning[groupKey(fileconfig)] < fileconfig.MaxHarvesters
+}
+
+// queueHarvester queues a file to be harvested from the given offset once a
+// harvester slot becomes available
+func (p *Prospector) queueHarvester(info *prospectorInfo, fileconfig *config.File, offset int64, partial bool) {
+	if !info.queued {
+		log.Info("Maximum harvesters reached, queueing: %s", info.file)
+	}
+
+	info.queued = true
+	info.queueOffset = offset
+	info.queuePartial = partial
+	info.fileConfig = fileconfig
+	info.streamConfig = &fileconfig.Stream
+	info.deadStatus = nil
+	info.inactiveStatus = nil
+	info.status = statusOk
+	p.queue[info] = info
+}
+
+// dequeueHarvester removes a file from the queue
+func (p *Prospector) dequeueHarvester(info *prospectorInfo) {
+	if !info.queued {
+		return
+	}
+
+	info.queued = false
+	delete(p.queue, info)
+}
+
+// startQueued starts queued harvesters while slots are available, oldest
+// modification time first. Files whose file group is at its maximum are
+// skipped so they do not hold up files from other file groups. Must be called
+// with the mutex held
+func (p *Prospector) startQueued() {
+	if len(p.queue) == 0 {
+		return
+	}
+
+	p.countHarvesters()
+
+	queue := make(harvesterQueue, 0, len(p.queue))
+	for _, info := range p.queue {
+		queue = append(queue, info)
+	}
+	sort.Sort(queue)
+
+	for _, info := range queue {
+		if !p.globalSlotAvailable() {
+			break
+		}
+		if !p.slotAvailable(info.fileConfig) {
+			continue
+		}
+
+		log.Info("Launching queued harvester: %s", info.file)
+		p.dequeueHarvester(info)
+		p.runHarvester(info, info.fileConfig, info.queueOffset, info.queuePartial)
+	}
+}
+
+// reloadQueue moves queued files to the file group with the same paths in a
+// reloaded configuration, so they start with its settings, and then starts any
+// that the new limits allow. Files whose file group no longer exists are
+// removed from the queue and are queued again by the next scan if they are
+// still matched by another file group
+func (p *Prospector) reloadQueue() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	groups := make(map[string]*config.File)
+	for configKey := range p.config.Files {
+		if p.config.Files[configKey].Type != "exec" {
+			groups[groupKey(&p.config.Files[configKey])] = &p.config.Files[configKey]
+		}
+	}
+
+	for _, info := range p.queue {
+		fileconfig, ok := groups[groupKey(info.fileConfig)]
+		if !ok {
+			p.dequeueHarvester(info)
+			continue
+		}
+
+		info.fileConfig = fileconfig
+		info.streamConfig = &fileconfig.Stream
+	}
+
+	p.startQueued()
+}
+
+// watchHarvester returns a channel that receives the finish status of a
+// harvester, and notifies the prospector when it finishes so that queued files
+// can start without waiting for the next scan
+func (p *Prospector) watchHarvester(h *harvester.Harvester) <-chan *harvester.FinishStatus {
+	finishChan := make(chan *harvester.FinishStatus, 1)
+
+	go func() {
+		finishChan <- <-h.OnFinish()
+
+		select {
+		case p.finished <- struct{}{}:
+		default:
+		}
+	}()
+
+	return finishChan
+}
+
+// modTime returns the last known modification time of the file, or the zero
+// time if it is not known
+func (pi *prospectorInfo) modTime() time.Time {
+	if stat := pi.identity.Stat(); stat != nil {
+		return stat.ModTime()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// writeQueueFiles writes files with the given names, each modified an hour
+// apart in the given order, oldest first
+func writeQueueFiles(t *testing.T, dir string, names ...string) []string {
+	paths := make([]string, len(names))
+	modTime := time.Now().Add(-time.Duration(len(names)) * time.Hour)
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(paths[i], []byte("line\n"), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %s", paths[i], err)
+		}
+		if err := os.Chtimes(paths[i], modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time of %s: %s", paths[i], err)
+		}
+		modTime = modTime.Add(time.Hour)
+	}
+	return paths
+}
+
+// waitFinished waits for the prospector to be notified that a harvester
+// finished, and then starts queued files as the prospector would
+func waitFinished(t *testing.T, p *Prospector) {
+	select {
+	case <-p.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for harvester to finish")
+	}
+
+	p.mutex.Lock()
+	p.startQueued()
+	p.mutex.Unlock()
+}
+
+func TestQueueOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, _ := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "*.log")}
+		fileconfig.MaxHarvesters = 1
+	})
+	defer stopHarvesters(p)
+
+	// The newest is found first, and the rest are found newest first too
+	paths := writeQueueFiles(t, dir, "oldest.log", "older.log", "newest.log")
+	for i := len(paths) - 1; i >= 0; i-- {
+		p.processFile(paths[i], &p.config.Files[0])
+	}
+
+	newest := p.prospectorindex[paths[2]]
+	if !newest.isRunning() {
+		t.Fatalf("First file found was not started")
+	}
+	if len(p.queue) != 2 || !p.prospectorindex[paths[0]].queued || !p.prospectorindex[paths[1]].queued {
+		t.Fatalf("Files over the limit were not queued: %d queued", len(p.queue))
+	}
+
+	// Queued files start as harvesters finish, least recently modified first
+	for i, expected := range []string{paths[0], paths[1]} {
+		running := newest
+		if i != 0 {
+			running = p.prospectorindex[paths[i-1]]
+		}
+		running.stop()
+		waitFinished(t, p)
+
+		info := p.prospectorindex[expected]
+		if info.queued || !info.isRunning() {
+			t.Fatalf("Queued file was not started next: %s", expected)
+		}
+		if p.numRunning != 1 || len(p.queue) != 1-i {
+			t.Fatalf("Wrong counts after starting %s: running=%d queued=%d", expected, p.numRunning, len(p.queue))
+		}
+	}
+}
+
+func TestQueueGeneralLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, _ := newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "*.log")}
+	})
+	defer stopHarvesters(p)
+	p.config.General.MaxHarvesters = 2
+
+	paths := writeQueueFiles(t, dir, "a.log", "b.log", "c.log")
+	for _, path := range paths {
+		p.processFile(path, &p.config.Files[0])
+	}
+
+	if p.numRunning != 2 || len(p.queue) != 1 || !p.prospectorindex[paths[2]].queued {
+		t.Fatalf("General limit was not applied: running=%d queued=%d", p.numRunning, len(p.queue))
+	}
+
+	// A queued file is started by a scan once there is a free slot, and not
+	// before
+	p.mutex.Lock()
+	p.startQueued()
+	p.mutex.Unlock()
+	if !p.prospectorindex[paths[2]].queued {
+		t.Fatalf("Queued file was started without a free slot")
+	}
+
+	p.prospectorindex[paths[0]].stop()
+	waitFinished(t, p)
+	if !p.prospectorindex[paths[2]].isRunning() || len(p.queue) != 0 {
+		t.Errorf("Queued file was not started when a harvester finished")
+	}
+}
+
+func TestQueueReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configure := func(maxHarvesters int64) func(*config.File) {
+		return func(fileconfig *config.File) {
+			fileconfig.Paths = []string{filepath.Join(dir, "*.log")}
+			fileconfig.MaxHarvesters = maxHarvesters
+		}
+	}
+
+	p, _ := newTestProspector(t, configure(1))
+	defer stopHarvesters(p)
+
+	paths := writeQueueFiles(t, dir, "a.log", "b.log", "c.log")
+	p.processFile(paths[0], &p.config.Files[0])
+	p.processFile(paths[1], &p.config.Files[0])
+
+	// Harvesters started before a reload still count towards the limit
+	reloaded, _ := newTestProspector(t, configure(1))
+	p.config = reloaded.config
+	p.reloadQueue()
+
+	queued := p.prospectorindex[paths[1]]
+	if !queued.queued || queued.fileConfig != &p.config.Files[0] {
+		t.Fatalf("Queued file was not moved to the reloaded file group")
+	}
+
+	p.mutex.Lock()
+	p.countHarvesters()
+	p.mutex.Unlock()
+	p.processFile(paths[2], &p.config.Files[0])
+	if !p.prospectorindex[paths[2]].queued || p.numRunning != 1 {
+		t.Fatalf("Limit was exceeded after reload: running=%d", p.numRunning)
+	}
+
+	// Raising the limit starts queued files straight away
+	reloaded, _ = newTestProspector(t, configure(3))
+	p.config = reloaded.config
+	p.reloadQueue()
+
+	if len(p.queue) != 0 || p.numRunning != 3 {
+		t.Errorf("Queued files were not started after the limit was raised: running=%d queued=%d", p.numRunning, len(p.queue))
+	}
+
+	// Files of a removed file group are dropped from the queue
+	reloaded, _ = newTestProspector(t, func(fileconfig *config.File) {
+		fileconfig.Paths = []string{filepath.Join(dir, "*.txt")}
+		fileconfig.MaxHarvesters = 1
+	})
+	info := p.prospectorindex[paths[0]]
+	info.stop()
+	info.wait()
+	p.mutex.Lock()
+	p.queueHarvester(info, info.fileConfig, 0, false)
+	p.mutex.Unlock()
+	p.config = reloaded.config
+	p.reloadQueue()
+
+	if info.queued || len(p.queue) != 0 {
+		t.Errorf("File of a removed file group is still queued")
+	}
+}
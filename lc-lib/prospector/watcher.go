@@ -0,0 +1,39 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package prospector
+
+// watchEvent describes a change within a watched directory
+type watchEvent struct {
+	// path is the file that changed, or empty if events were lost and a full
+	// scan is required
+	path string
+	// removed is true if the file was deleted or moved away
+	removed bool
+}
+
+// watcher is implemented by filesystem notification backends that allow the
+// prospector to react to changes without waiting for the next scan
+type watcher interface {
+	// Watch updates the set of directories being watched
+	Watch(dirs []string)
+	// NumWatched returns the number of directories being watched
+	NumWatched() int
+	// Events returns the channel that batches of events are sent to
+	Events() <-chan []*watchEvent
+	// Close stops watching and releases resources
+	Close()
+}
@@ -0,0 +1,196 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package prospector
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_ONLYDIR
+
+// inotifyWatcher watches directories using the Linux inotify API
+type inotifyWatcher struct {
+	mutex   sync.Mutex
+	file    *os.File
+	fd      int
+	dirs    map[string]int
+	wds     map[int]string
+	events  chan []*watchEvent
+	stopped chan interface{}
+}
+
+// newInotifyWatcher creates a new inotify instance and starts reading events
+// from it
+func newInotifyWatcher() (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	ret := &inotifyWatcher{
+		// Non-blocking descriptor means Close will interrupt a pending Read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		dirs:    make(map[string]int),
+		wds:     make(map[int]string),
+		events:  make(chan []*watchEvent, 16),
+		stopped: make(chan interface{}),
+	}
+
+	go ret.readRoutine()
+
+	return ret, nil
+}
+
+// Watch adds watches for new directories and removes watches for directories
+// no longer in the given list
+func (w *inotifyWatcher) Watch(dirs []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	wanted := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		wanted[dir] = true
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			log.Warning("Failed to watch %s, changes will be found during the next scan: %s", dir, err)
+			continue
+		}
+
+		log.Debug("Watching directory for changes: %s", dir)
+		w.dirs[dir] = wd
+		w.wds[wd] = dir
+	}
+
+	for dir, wd := range w.dirs {
+		if wanted[dir] {
+			continue
+		}
+
+		log.Debug("No longer watching directory for changes: %s", dir)
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.dirs, dir)
+		delete(w.wds, wd)
+	}
+}
+
+// NumWatched returns the number of directories being watched
+func (w *inotifyWatcher) NumWatched() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.dirs)
+}
+
+// Events returns the channel that batches of events are sent to
+func (w *inotifyWatcher) Events() <-chan []*watchEvent {
+	return w.events
+}
+
+// Close stops watching and releases resources
+func (w *inotifyWatcher) Close() {
+	close(w.stopped)
+	w.file.Close()
+}
+
+// readRoutine reads events from the inotify descriptor and sends them in
+// batches to the events channel
+func (w *inotifyWatcher) readRoutine() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.stopped:
+			default:
+				log.Error("Failed to read inotify events, changes will be found during the next scan: %s", err)
+			}
+			return
+		}
+
+		events := w.parseEvents(buf[:n])
+		if len(events) == 0 {
+			continue
+		}
+
+		select {
+		case w.events <- events:
+		case <-w.stopped:
+			return
+		}
+	}
+}
+
+// parseEvents converts raw inotify event data into watch events
+func (w *inotifyWatcher) parseEvents(buf []byte) []*watchEvent {
+	var events []*watchEvent
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		offset = nameStart + int(raw.Len)
+
+		if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			// Events were lost so we need a full scan
+			events = append(events, &watchEvent{})
+			continue
+		}
+
+		dir, ok := w.wds[int(raw.Wd)]
+		if !ok {
+			continue
+		}
+
+		if raw.Mask&syscall.IN_IGNORED != 0 {
+			// Directory was removed or unmounted and the watch is gone
+			delete(w.dirs, dir)
+			delete(w.wds, int(raw.Wd))
+			continue
+		}
+
+		if raw.Len == 0 || offset > len(buf) {
+			continue
+		}
+
+		// Name is NUL padded
+		name := buf[nameStart:offset]
+		for i, c := range name {
+			if c == 0 {
+				name = name[:i]
+				break
+			}
+		}
+
+		events = append(events, &watchEvent{
+			path:    filepath.Join(dir, string(name)),
+			removed: raw.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0,
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// inotifyEventBytes returns raw inotify event data for a single event, with the
+// name padded as the kernel does
+func inotifyEventBytes(wd int, mask uint32, name string) []byte {
+	nameLen := 0
+	if name != "" {
+		nameLen = (len(name)/16 + 1) * 16
+	}
+
+	buf := make([]byte, syscall.SizeofInotifyEvent+nameLen)
+	raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+	raw.Wd = int32(wd)
+	raw.Mask = mask
+	raw.Len = uint32(nameLen)
+	copy(buf[syscall.SizeofInotifyEvent:], name)
+
+	return buf
+}
+
+func newTestInotifyWatcher() *inotifyWatcher {
+	return &inotifyWatcher{
+		dirs: map[string]int{"/var/log": 1, "/var/log/app": 2},
+		wds:  map[int]string{1: "/var/log", 2: "/var/log/app"},
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	w := newTestInotifyWatcher()
+
+	var buf []byte
+	buf = append(buf, inotifyEventBytes(1, syscall.IN_CREATE, "messages")...)
+	buf = append(buf, inotifyEventBytes(2, syscall.IN_MOVED_TO, "app.log.1")...)
+	buf = append(buf, inotifyEventBytes(2, syscall.IN_MOVED_FROM, "app.log")...)
+	buf = append(buf, inotifyEventBytes(1, syscall.IN_DELETE, "a-much-longer-file-name.log")...)
+	buf = append(buf, inotifyEventBytes(3, syscall.IN_CREATE, "unknown")...)
+	buf = append(buf, inotifyEventBytes(1, syscall.IN_CREATE, "")...)
+
+	events := w.parseEvents(buf)
+
+	expected := []watchEvent{
+		{"/var/log/messages", false},
+		{"/var/log/app/app.log.1", false},
+		{"/var/log/app/app.log", true},
+		{"/var/log/a-much-longer-file-name.log", true},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Wrong event count: %d", len(events))
+	}
+	for i, event := range events {
+		if *event != expected[i] {
+			t.Errorf("Wrong event[%d]: %v", i, *event)
+		}
+	}
+}
+
+func TestParseEventsOverflow(t *testing.T) {
+	w := newTestInotifyWatcher()
+
+	events := w.parseEvents(inotifyEventBytes(-1, syscall.IN_Q_OVERFLOW, ""))
+	if len(events) != 1 || events[0].path != "" {
+		t.Errorf("Overflow did not request a full scan: %v", events)
+	}
+}
+
+func TestParseEventsIgnored(t *testing.T) {
+	w := newTestInotifyWatcher()
+
+	events := w.parseEvents(inotifyEventBytes(2, syscall.IN_IGNORED, ""))
+	if len(events) != 0 {
+		t.Errorf("Unexpected events: %v", events)
+	}
+	if _, ok := w.dirs["/var/log/app"]; ok {
+		t.Error("Removed watch is still recorded")
+	}
+	if _, ok := w.wds[2]; ok {
+		t.Error("Removed watch descriptor is still recorded")
+	}
+}
+
+func TestParseEventsTruncated(t *testing.T) {
+	w := newTestInotifyWatcher()
+
+	buf := inotifyEventBytes(1, syscall.IN_CREATE, "messages")
+	events := w.parseEvents(buf[:len(buf)-1])
+	if len(events) != 0 {
+		t.Errorf("Unexpected events from truncated data: %v", events)
+	}
+}
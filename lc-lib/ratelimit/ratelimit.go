@@ -0,0 +1,174 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package ratelimit implements token bucket rate limiting of lines and bytes
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a single token bucket. It is not safe for concurrent use
+type bucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+}
+
+// newBucket creates a bucket for the given rate per second that can hold
+// enough tokens for the given burst period. The bucket starts full
+func newBucket(rate int64, burst time.Duration) *bucket {
+	capacity := float64(rate) * burst.Seconds()
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &bucket{
+		rate:     float64(rate),
+		capacity: capacity,
+		tokens:   capacity,
+	}
+}
+
+// refill adds the tokens accumulated over the given period
+func (b *bucket) refill(elapsed time.Duration) {
+	b.tokens += elapsed.Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// wait returns how long until the given number of tokens are available
+func (b *bucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Limiter limits the rate of lines and bytes. It is safe for concurrent use so
+// it can be shared by many harvesters
+type Limiter struct {
+	mutex sync.Mutex
+
+	lines *bucket
+	bytes *bucket
+	last  time.Time
+	now   func() time.Time
+}
+
+// NewLimiter creates a new limiter for the given number of lines and bytes per
+// second, either of which can be 0 for no limit. Burst is the period of time
+// whose allowance can be saved up and used all at once. Returns nil if there
+// are no limits
+func NewLimiter(lines int64, bytes int64, burst time.Duration) *Limiter {
+	if lines == 0 && bytes == 0 {
+		return nil
+	}
+
+	ret := &Limiter{
+		now: time.Now,
+	}
+
+	if lines != 0 {
+		ret.lines = newBucket(lines, burst)
+	}
+	if bytes != 0 {
+		ret.bytes = newBucket(bytes, burst)
+	}
+
+	ret.last = ret.now()
+
+	return ret
+}
+
+// refill adds the tokens accumulated since the last call
+func (l *Limiter) refill() {
+	now := l.now()
+	elapsed := now.Sub(l.last)
+	l.last = now
+
+	if l.lines != nil {
+		l.lines.refill(elapsed)
+	}
+	if l.bytes != nil {
+		l.bytes.refill(elapsed)
+	}
+}
+
+// wait returns how long until a line of the given length is allowed
+func (l *Limiter) wait(length int) time.Duration {
+	var wait time.Duration
+
+	if l.lines != nil {
+		wait = l.lines.wait(1)
+	}
+
+	if l.bytes != nil {
+		// Lines longer than the bucket can hold are allowed once it is full
+		n := float64(length)
+		if n > l.bytes.capacity {
+			n = l.bytes.capacity
+		}
+		if bytesWait := l.bytes.wait(n); bytesWait > wait {
+			wait = bytesWait
+		}
+	}
+
+	return wait
+}
+
+// take removes the tokens for a line of the given length
+func (l *Limiter) take(length int) {
+	if l.lines != nil {
+		l.lines.tokens--
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= float64(length)
+	}
+}
+
+// Allow returns true and uses up the allowance for a line of the given length
+// if it is within the limits, or returns false if it is not
+func (l *Limiter) Allow(length int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.refill()
+
+	if l.wait(length) != 0 {
+		return false
+	}
+
+	l.take(length)
+	return true
+}
+
+// Reserve uses up the allowance for a line of the given length and returns how
+// long the caller must wait before processing it in order to stay within the
+// limits
+func (l *Limiter) Reserve(length int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.refill()
+
+	wait := l.wait(length)
+	l.take(length)
+	return wait
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(lines int64, bytes int64, burst time.Duration) (*Limiter, *testClock) {
+	clock := &testClock{now: time.Now()}
+	limiter := NewLimiter(lines, bytes, burst)
+	limiter.now = clock.Now
+	limiter.last = clock.now
+	return limiter, clock
+}
+
+func TestLimiterNone(t *testing.T) {
+	if limiter := NewLimiter(0, 0, time.Second); limiter != nil {
+		t.Error("Limiter was created without any limits")
+	}
+}
+
+func TestLimiterLines(t *testing.T) {
+	limiter, clock := newTestLimiter(10, 0, time.Second)
+
+	for i := 0; i < 10; i++ {
+		if !limiter.Allow(100) {
+			t.Fatalf("Line %d was not allowed within burst", i)
+		}
+	}
+
+	if limiter.Allow(100) {
+		t.Error("Line was allowed beyond burst")
+	}
+
+	clock.now = clock.now.Add(100 * time.Millisecond)
+
+	if !limiter.Allow(100) {
+		t.Error("Line was not allowed after refill")
+	}
+	if limiter.Allow(100) {
+		t.Error("Line was allowed beyond refill")
+	}
+}
+
+func TestLimiterBytes(t *testing.T) {
+	limiter, clock := newTestLimiter(0, 1000, time.Second)
+
+	if wait := limiter.Reserve(600); wait != 0 {
+		t.Errorf("Unexpected wait within burst: %v", wait)
+	}
+
+	if wait := limiter.Reserve(600); wait != 200*time.Millisecond {
+		t.Errorf("Unexpected wait beyond burst: %v", wait)
+	}
+
+	clock.now = clock.now.Add(200 * time.Millisecond)
+
+	// Previous reservation took the bucket negative so it is now empty
+	if wait := limiter.Reserve(100); wait != 100*time.Millisecond {
+		t.Errorf("Unexpected wait after partial refill: %v", wait)
+	}
+}
+
+func TestLimiterBytesLongLine(t *testing.T) {
+	limiter, _ := newTestLimiter(0, 1000, time.Second)
+
+	// Lines longer than the burst are allowed when the bucket is full
+	if !limiter.Allow(5000) {
+		t.Error("Long line was not allowed with a full bucket")
+	}
+}
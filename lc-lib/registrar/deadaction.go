@@ -0,0 +1,105 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// deadAction holds a dead action that is waiting for all events from a file
+// to be acknowledged
+type deadAction struct {
+	offset       int64
+	fileinfo     os.FileInfo
+	streamConfig *config.Stream
+}
+
+// checkDeadAction performs the pending dead action if the acknowledged offset
+// has reached the final offset of the file
+func (fs *FileState) checkDeadAction() {
+	if fs.deadAction == nil || fs.Offset < fs.deadAction.offset {
+		return
+	}
+
+	action := fs.deadAction
+	fs.deadAction = nil
+
+	// Never delete data that was not shipped
+	if fs.Partial && action.streamConfig.DeadAction == "delete" {
+		log.Warning("Skipping dead action for %s: file was not harvested from the beginning", *fs.Source)
+		return
+	}
+
+	action.perform(*fs.Source)
+}
+
+// perform carries out the dead action, but only if the file at the path is
+// still the file the harvester finished with, and it has not been modified
+func (a *deadAction) perform(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Warning("Skipping dead action for %s: %s", path, err)
+		return
+	}
+
+	if !os.SameFile(info, a.fileinfo) || info.Size() != a.fileinfo.Size() || !info.ModTime().Equal(a.fileinfo.ModTime()) {
+		log.Info("Skipping dead action for %s: file was modified", path)
+		return
+	}
+
+	switch a.streamConfig.DeadAction {
+	case "delete":
+		if err := os.Remove(path); err != nil {
+			log.Error("Failed to delete dead file %s: %s", path, err)
+			return
+		}
+
+		log.Info("Deleted dead file: %s", path)
+	case "rename":
+		target := a.renameTarget(path)
+
+		if _, err := os.Lstat(target); err == nil {
+			log.Error("Failed to rename dead file %s: %s already exists", path, target)
+			return
+		}
+
+		if err := os.Rename(path, target); err != nil {
+			log.Error("Failed to rename dead file %s: %s", path, err)
+			return
+		}
+
+		log.Info("Renamed dead file: %s -> %s", path, target)
+	}
+}
+
+// renameTarget returns the path a dead file should be renamed to. A relative
+// rename directory is relative to the directory containing the file
+func (a *deadAction) renameTarget(path string) string {
+	dir := filepath.Dir(path)
+	if a.streamConfig.DeadRenameDir != "" {
+		if filepath.IsAbs(a.streamConfig.DeadRenameDir) {
+			dir = a.streamConfig.DeadRenameDir
+		} else {
+			dir = filepath.Join(dir, a.streamConfig.DeadRenameDir)
+		}
+	}
+
+	return filepath.Join(dir, filepath.Base(path)+a.streamConfig.DeadRenameSuffix)
+}
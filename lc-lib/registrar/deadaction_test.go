@@ -0,0 +1,129 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func TestRenameTarget(t *testing.T) {
+	checks := []struct {
+		dir, suffix, expected string
+	}{
+		{"", ".done", "/var/log/app/app.log.done"},
+		{"archive", "", "/var/log/app/archive/app.log"},
+		{"/var/archive", ".old", "/var/archive/app.log.old"},
+	}
+
+	for _, check := range checks {
+		action := &deadAction{
+			streamConfig: &config.Stream{DeadRenameDir: check.dir, DeadRenameSuffix: check.suffix},
+		}
+		if result := action.renameTarget("/var/log/app/app.log"); result != check.expected {
+			t.Errorf("Wrong rename target for %q and %q: %s != %s", check.dir, check.suffix, result, check.expected)
+		}
+	}
+}
+
+// newDeadFileState creates a file with the given contents and a file state
+// with a pending dead action for it
+func newDeadFileState(t *testing.T, dir string, action string, partial bool) (*FileState, string) {
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("line\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %s", path, err)
+	}
+
+	state := &FileState{Source: &path, Partial: partial}
+	state.PopulateFileIds(info)
+	state.deadAction = &deadAction{
+		offset:       5,
+		fileinfo:     info,
+		streamConfig: &config.Stream{DeadAction: action, DeadRenameSuffix: ".done"},
+	}
+
+	return state, path
+}
+
+func TestCheckDeadAction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registrar")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	state, path := newDeadFileState(t, dir, "delete", false)
+
+	// Nothing happens until the final offset is acknowledged
+	state.Offset = 4
+	state.checkDeadAction()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("File was deleted before the final offset was acknowledged: %s", err)
+	}
+
+	state.Offset = 5
+	state.checkDeadAction()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File was not deleted: %v", err)
+	}
+	if state.deadAction != nil {
+		t.Error("Dead action is still pending after being performed")
+	}
+
+	state, path = newDeadFileState(t, dir, "rename", false)
+	state.Offset = 5
+	state.checkDeadAction()
+	if _, err := os.Stat(path + ".done"); err != nil {
+		t.Errorf("File was not renamed: %s", err)
+	}
+}
+
+func TestCheckDeadActionSkipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registrar")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files not harvested from the beginning are never deleted
+	state, path := newDeadFileState(t, dir, "delete", true)
+	state.Offset = 5
+	state.checkDeadAction()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Partially harvested file was deleted: %s", err)
+	}
+
+	// Files modified since they were harvested are left alone
+	state, path = newDeadFileState(t, dir, "delete", false)
+	if err := ioutil.WriteFile(path, []byte("line\nmore\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+	state.Offset = 5
+	state.checkDeadAction()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Modified file was deleted: %s", err)
+	}
+}
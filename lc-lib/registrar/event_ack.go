@@ -53,5 +53,6 @@ func (e *AckEvent) Process(state map[core.Stream]*FileState) {
 		}
 
 		state[event.Stream].Offset = event.Offset
+		state[event.Stream].checkDeadAction()
 	}
 }
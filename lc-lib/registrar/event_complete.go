@@ -0,0 +1,50 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// CompleteEvent is a registrar event which records the offset at which a
+// compressed file ends, so that it is not decompressed again after a restart
+// once everything up to that offset is acknowledged
+type CompleteEvent struct {
+	stream core.Stream
+	offset int64
+}
+
+// NewCompleteEvent creates a new registrar complete event
+func NewCompleteEvent(stream core.Stream, offset int64) *CompleteEvent {
+	return &CompleteEvent{
+		stream: stream,
+		offset: offset,
+	}
+}
+
+// Process stores the complete offset for the file
+func (e *CompleteEvent) Process(state map[core.Stream]*FileState) {
+	fileState, ok := state[e.stream]
+	if !ok {
+		log.Warning("Registrar received a complete event for UNKNOWN (%p)", e.stream)
+		return
+	}
+
+	log.Debug("Registrar received a complete event for %s at offset %d", *fileState.Source, e.offset)
+
+	fileState.CompleteOffset = &e.offset
+}
@@ -0,0 +1,65 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package registrar
+
+import (
+	"os"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// DeadEvent is a registrar event which requests the dead action for a file
+// be performed once all events up to the given offset are acknowledged
+type DeadEvent struct {
+	stream       core.Stream
+	offset       int64
+	fileinfo     os.FileInfo
+	streamConfig *config.Stream
+}
+
+// NewDeadEvent creates a new registrar dead event. The fileinfo should be the
+// last stat of the file taken by the harvester so that the action can be
+// skipped if the file changes
+func NewDeadEvent(stream core.Stream, offset int64, fileinfo os.FileInfo, streamConfig *config.Stream) *DeadEvent {
+	return &DeadEvent{
+		stream:       stream,
+		offset:       offset,
+		fileinfo:     fileinfo,
+		streamConfig: streamConfig,
+	}
+}
+
+// Process stores the pending dead action and performs it if the offset was
+// already acknowledged
+func (e *DeadEvent) Process(state map[core.Stream]*FileState) {
+	fileState, ok := state[e.stream]
+	if !ok {
+		log.Warning("Registrar received a dead event for UNKNOWN (%p)", e.stream)
+		return
+	}
+
+	log.Debug("Registrar received a dead event for %s at offset %d", *fileState.Source, e.offset)
+
+	fileState.deadAction = &deadAction{
+		offset:       e.offset,
+		fileinfo:     e.fileinfo,
+		streamConfig: e.streamConfig,
+	}
+
+	fileState.checkDeadAction()
+}
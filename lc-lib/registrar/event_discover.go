@@ -42,8 +42,9 @@ func (e *DiscoverEvent) Process(state map[core.Stream]*FileState) {
 
 	// A new file we need to save offset information for so we can resume
 	state[e.stream] = &FileState{
-		Source: &e.source,
-		Offset: e.offset,
+		Source:  &e.source,
+		Offset:  e.offset,
+		Partial: e.offset != 0,
 	}
 	state[e.stream].PopulateFileIds(e.fileinfo)
 }
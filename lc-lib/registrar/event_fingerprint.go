@@ -0,0 +1,49 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// FingerprintEvent is a registrar event that updates the stored content
+// fingerprint of a file
+type FingerprintEvent struct {
+	stream      core.Stream
+	fingerprint *Fingerprint
+}
+
+// NewFingerprintEvent creates a new fingerprint event
+func NewFingerprintEvent(stream core.Stream, fingerprint *Fingerprint) *FingerprintEvent {
+	return &FingerprintEvent{
+		stream:      stream,
+		fingerprint: fingerprint,
+	}
+}
+
+// Process stores the fingerprint in the registrar state
+func (e *FingerprintEvent) Process(state map[core.Stream]*FileState) {
+	_, isFound := state[e.stream]
+	if !isFound {
+		// This is probably stdin or a deleted file we can't resume
+		return
+	}
+
+	log.Debug("Registrar received a fingerprint event for %s", *state[e.stream].Source)
+
+	state[e.stream].Fingerprint = e.fingerprint
+}
@@ -25,8 +25,17 @@ import (
 
 type FileState struct {
 	FileStateOS
-	Source *string `json:"source,omitempty"`
-	Offset int64   `json:"offset,omitempty"`
+	Source      *string      `json:"source,omitempty"`
+	Offset      int64        `json:"offset,omitempty"`
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+	// CompleteOffset is the offset at which a compressed file ends, once it
+	// has been read completely
+	CompleteOffset *int64 `json:"complete_offset,omitempty"`
+	// Partial is true if harvesting did not start at the beginning of the
+	// file, so some of its data was never shipped
+	Partial bool `json:"partial,omitempty"`
+
+	deadAction *deadAction
 }
 
 type FileInfo struct {
@@ -43,6 +52,12 @@ func (fs *FileInfo) SameAs(info os.FileInfo) bool {
 	return os.SameFile(info, fs.fileinfo)
 }
 
+func (fs *FileInfo) SameInode(info os.FileInfo) bool {
+	state := &FileStateOS{}
+	state.PopulateFileIds(fs.fileinfo)
+	return state.SameInode(info)
+}
+
 func (fs *FileInfo) Stat() os.FileInfo {
 	return fs.fileinfo
 }
@@ -62,6 +77,7 @@ func (fs *FileState) Update(fileinfo os.FileInfo, identity *FileIdentity) {
 
 type FileIdentity interface {
 	SameAs(os.FileInfo) bool
+	SameInode(os.FileInfo) bool
 	Stat() os.FileInfo
 	Update(os.FileInfo, *FileIdentity)
 }
@@ -40,3 +40,11 @@ func (fs *FileStateOS) SameAs(info os.FileInfo) bool {
 	state.PopulateFileIds(info)
 	return (fs.Inode == state.Inode && fs.Device == state.Device)
 }
+
+// SameInode returns true if the info has the same inode as the state,
+// regardless of the device it is on
+func (fs *FileStateOS) SameInode(info os.FileInfo) bool {
+	state := &FileStateOS{}
+	state.PopulateFileIds(info)
+	return fs.Inode == state.Inode
+}
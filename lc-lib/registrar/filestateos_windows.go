@@ -72,3 +72,11 @@ func (fs *FileStateOS) SameAs(info os.FileInfo) bool {
 	state.PopulateFileIds(info)
 	return (fs.Vol == state.Vol && fs.IdxHi == state.IdxHi && fs.IdxLo == state.IdxLo)
 }
+
+// SameInode returns true if the info has the same file index as the state,
+// regardless of the volume it is on
+func (fs *FileStateOS) SameInode(info os.FileInfo) bool {
+	state := &FileStateOS{}
+	state.PopulateFileIds(info)
+	return (fs.IdxHi == state.IdxHi && fs.IdxLo == state.IdxLo)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Fingerprint identifies a file by a hash of the data at its start, so that a
+// new file reusing the inode of an old one can be told apart from it
+type Fingerprint struct {
+	Hash   string `json:"hash"`
+	Length int64  `json:"length"`
+}
+
+// readFingerprintData reads up to size bytes from the start of the file
+func readFingerprintData(path string, size int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, size)
+	n, err := io.ReadFull(file, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	return data[:n], err
+}
+
+// newFingerprint hashes the given data, returning nil if there is no data
+func newFingerprint(data []byte) *Fingerprint {
+	if len(data) == 0 {
+		return nil
+	}
+
+	hash := sha1.Sum(data)
+	return &Fingerprint{
+		Hash:   hex.EncodeToString(hash[:]),
+		Length: int64(len(data)),
+	}
+}
+
+// CalculateFingerprint returns the fingerprint of up to size bytes from the
+// start of the file, or nil if the file is empty
+func CalculateFingerprint(path string, size int64) (*Fingerprint, error) {
+	data, err := readFingerprintData(path, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFingerprint(data), nil
+}
+
+// Check returns true if the file starts with the same data that this
+// fingerprint was calculated from. As files that were smaller than the
+// fingerprint size grow, the fingerprint will cover more data, so the
+// fingerprint to store in place of this one is also returned
+func (f *Fingerprint) Check(path string, size int64) (bool, *Fingerprint, error) {
+	data, err := readFingerprintData(path, size)
+	if err != nil {
+		return false, nil, err
+	}
+
+	// The file may now be smaller than the data we fingerprinted
+	if !f.Matches(data) {
+		return false, nil, nil
+	}
+
+	if int64(len(data)) == f.Length {
+		return true, f, nil
+	}
+
+	return true, newFingerprint(data), nil
+}
+
+// Matches returns true if the given data starts with the data that this
+// fingerprint was calculated from
+func (f *Fingerprint) Matches(data []byte) bool {
+	if int64(len(data)) < f.Length {
+		return false
+	}
+
+	check := newFingerprint(data[:f.Length])
+	return check != nil && check.Hash == f.Hash
+}
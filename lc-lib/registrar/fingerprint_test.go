@@ -0,0 +1,88 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFingerprint(t *testing.T) {
+	if fingerprint := newFingerprint(nil); fingerprint != nil {
+		t.Errorf("Fingerprint returned for no data: %v", fingerprint)
+	}
+
+	fingerprint := newFingerprint([]byte("line\n"))
+	if fingerprint.Length != 5 || fingerprint.Hash != "6bfa09d82ce3e898ad4641ae13dd4fdb9cf0d76b" {
+		t.Errorf("Wrong fingerprint: %v", fingerprint)
+	}
+}
+
+func TestFingerprintCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registrar")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %s", path, err)
+		}
+	}
+
+	if fingerprint, err := CalculateFingerprint(path, 8); err == nil || fingerprint != nil {
+		t.Errorf("Missing file did not fail: %v", fingerprint)
+	}
+
+	write("")
+	if fingerprint, err := CalculateFingerprint(path, 8); err != nil || fingerprint != nil {
+		t.Errorf("Fingerprint returned for empty file: %v, %v", fingerprint, err)
+	}
+
+	write("line\n")
+	fingerprint, err := CalculateFingerprint(path, 8)
+	if err != nil || fingerprint == nil || fingerprint.Length != 5 {
+		t.Fatalf("Wrong fingerprint for short file: %v, %v", fingerprint, err)
+	}
+
+	// Unchanged file matches and keeps the same fingerprint
+	if matched, updated, err := fingerprint.Check(path, 8); !matched || updated != fingerprint || err != nil {
+		t.Errorf("Unchanged file did not match: %t, %v, %v", matched, updated, err)
+	}
+
+	// Growing file matches, and the fingerprint grows up to the size
+	write("line\nmore lines\n")
+	matched, updated, err := fingerprint.Check(path, 8)
+	if !matched || err != nil || updated == nil || updated.Length != 8 || updated.Hash != newFingerprint([]byte("line\nmor")).Hash {
+		t.Errorf("Grown file did not match: %t, %v, %v", matched, updated, err)
+	}
+
+	// Rewritten and truncated files do not match
+	write("other\nlines\n")
+	if matched, _, err := fingerprint.Check(path, 8); matched || err != nil {
+		t.Errorf("Rewritten file matched: %v", err)
+	}
+
+	write("lin")
+	if matched, _, err := fingerprint.Check(path, 8); matched || err != nil {
+		t.Errorf("Truncated file matched: %v", err)
+	}
+}
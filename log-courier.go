@@ -109,7 +109,7 @@ func (lc *logCourier) Run() {
 
 	// If reading from stdin, don't start prospector, directly start a harvester
 	if lc.stdin {
-		lc.harvester = harvester.NewHarvester(nil, lc.config, &lc.config.Stdin, 0)
+		lc.harvester = harvester.NewHarvester(nil, lc.config, &lc.config.Stdin, 0, nil)
 		lc.harvester.Start(spoolerImp.Connect())
 		harvesterWait = lc.harvester.OnFinish()
 	} else {